@@ -0,0 +1,259 @@
+// Package expression defines the AST for the boolean/arithmetic expressions
+// embedded in filter() and join() operations, e.g. the body of
+// filter(exp:{"t1"=="val1" and $ == 10}).
+package expression
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Node is a node in an expression tree: a BinaryNode or one of the leaf
+// Reference/Literal node types. NodeKind identifies the concrete type for
+// JSON marshalling, e.g. "binary" or "stringLiteral".
+type Node interface {
+	NodeKind() string
+}
+
+var nodeCreators = make(map[string]func() Node)
+
+// RegisterNode registers c as the constructor used to decode a Node whose
+// NodeKind() is kind. Every Node implementation in this package registers
+// itself in init, and custom operations that embed their own Node types can
+// do the same, so a tree of Nodes round-trips through JSON without a
+// central type switch. That holds only where every interface-typed child
+// is written in the kind-tagged envelope, as BinaryNode and ListLiteralNode
+// do; UnaryNode has no JSON methods, so a tree holding one does not decode.
+func RegisterNode(kind string, c func() Node) {
+	if nodeCreators[kind] != nil {
+		panic(fmt.Errorf("duplicate registration for expression node kind %v", kind))
+	}
+	nodeCreators[kind] = c
+}
+
+func init() {
+	RegisterNode("binary", func() Node { return new(BinaryNode) })
+	RegisterNode("reference", func() Node { return new(ReferenceNode) })
+	RegisterNode("stringLiteral", func() Node { return new(StringLiteralNode) })
+	RegisterNode("integerLiteral", func() Node { return new(IntegerLiteralNode) })
+	RegisterNode("floatLiteral", func() Node { return new(FloatLiteralNode) })
+	RegisterNode("booleanLiteral", func() Node { return new(BooleanLiteralNode) })
+	RegisterNode("regexpLiteral", func() Node { return new(RegexpLiteralNode) })
+	RegisterNode("durationLiteral", func() Node { return new(DurationLiteralNode) })
+	RegisterNode("timeLiteral", func() Node { return new(TimeLiteralNode) })
+}
+
+// nodeEnvelope tags an encoded Node with its NodeKind, so unmarshalNode
+// knows which registered constructor to decode it with.
+type nodeEnvelope struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+func marshalNode(n Node) (json.RawMessage, error) {
+	if n == nil {
+		return json.Marshal(nil)
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(nodeEnvelope{Kind: n.NodeKind(), Data: data})
+}
+
+func unmarshalNode(data []byte) (Node, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var env nodeEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	newNode, ok := nodeCreators[env.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown expression node kind %q", env.Kind)
+	}
+	n := newNode()
+	if err := json.Unmarshal(env.Data, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Expression wraps the root of an expression tree, e.g. the body of a
+// filter() or join() argument.
+type Expression struct {
+	Root Node
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e Expression) MarshalJSON() ([]byte, error) {
+	root, err := marshalNode(e.Root)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Root json.RawMessage `json:"root"`
+	}{Root: root})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *Expression) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Root json.RawMessage `json:"root"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	root, err := unmarshalNode(raw.Root)
+	if err != nil {
+		return err
+	}
+	e.Root = root
+	return nil
+}
+
+// Operator identifies the operator of a BinaryNode.
+type Operator string
+
+const (
+	AndOperator              Operator = "and"
+	OrOperator                Operator = "or"
+	NotOperator               Operator = "not"
+	EqualOperator             Operator = "=="
+	NotEqualOperator          Operator = "!="
+	RegexpMatchOperator       Operator = "=~"
+	RegexpNotMatchOperator    Operator = "!~"
+	LessThanOperator          Operator = "<"
+	LessThanEqualOperator     Operator = "<="
+	GreaterThanOperator       Operator = ">"
+	GreaterThanEqualOperator  Operator = ">="
+	AdditionOperator          Operator = "+"
+	SubtractionOperator       Operator = "-"
+	MultiplicationOperator    Operator = "*"
+	DivisionOperator          Operator = "/"
+)
+
+// BinaryNode applies Operator to Left and Right, e.g. "t1"=="val1" or a+b.
+type BinaryNode struct {
+	Operator Operator
+	Left     Node
+	Right    Node
+}
+
+// NodeKind implements Node.
+func (*BinaryNode) NodeKind() string { return "binary" }
+
+// MarshalJSON implements json.Marshaler; Left and Right are interface-typed
+// so they need the same kind-tagged envelope as Expression.Root.
+func (b BinaryNode) MarshalJSON() ([]byte, error) {
+	left, err := marshalNode(b.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := marshalNode(b.Right)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Operator Operator        `json:"operator"`
+		Left     json.RawMessage `json:"left"`
+		Right    json.RawMessage `json:"right"`
+	}{
+		Operator: b.Operator,
+		Left:     left,
+		Right:    right,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *BinaryNode) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Operator Operator        `json:"operator"`
+		Left     json.RawMessage `json:"left"`
+		Right    json.RawMessage `json:"right"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	left, err := unmarshalNode(raw.Left)
+	if err != nil {
+		return err
+	}
+	right, err := unmarshalNode(raw.Right)
+	if err != nil {
+		return err
+	}
+	b.Operator = raw.Operator
+	b.Left = left
+	b.Right = right
+	return nil
+}
+
+// ReferenceNode refers to a tag, field, or identifier by Name; Kind
+// disambiguates which ("tag", "field", or "identifier").
+type ReferenceNode struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// NodeKind implements Node.
+func (*ReferenceNode) NodeKind() string { return "reference" }
+
+// StringLiteralNode is a quoted string literal, e.g. "val1".
+type StringLiteralNode struct {
+	Value string `json:"value"`
+}
+
+// NodeKind implements Node.
+func (*StringLiteralNode) NodeKind() string { return "stringLiteral" }
+
+// IntegerLiteralNode is an integer literal, e.g. the 10 in $ == 10.
+type IntegerLiteralNode struct {
+	Value int64 `json:"value"`
+}
+
+// NodeKind implements Node.
+func (*IntegerLiteralNode) NodeKind() string { return "integerLiteral" }
+
+// FloatLiteralNode is a floating point literal, e.g. the 10.5 in $ == 10.5.
+type FloatLiteralNode struct {
+	Value float64 `json:"value"`
+}
+
+// NodeKind implements Node.
+func (*FloatLiteralNode) NodeKind() string { return "floatLiteral" }
+
+// BooleanLiteralNode is a boolean literal, e.g. true or false.
+type BooleanLiteralNode struct {
+	Value bool `json:"value"`
+}
+
+// NodeKind implements Node.
+func (*BooleanLiteralNode) NodeKind() string { return "booleanLiteral" }
+
+// RegexpLiteralNode is a regular expression literal, e.g. /val1/. Value
+// holds the pattern text, not a compiled *regexp.Regexp, so the node stays
+// trivially JSON-marshalable.
+type RegexpLiteralNode struct {
+	Value string `json:"value"`
+}
+
+// NodeKind implements Node.
+func (*RegexpLiteralNode) NodeKind() string { return "regexpLiteral" }
+
+// DurationLiteralNode is a duration literal, e.g. 1h.
+type DurationLiteralNode struct {
+	Value int64 `json:"value"` // nanoseconds
+}
+
+// NodeKind implements Node.
+func (*DurationLiteralNode) NodeKind() string { return "durationLiteral" }
+
+// TimeLiteralNode is an absolute RFC3339 time literal.
+type TimeLiteralNode struct {
+	Value string `json:"value"`
+}
+
+// NodeKind implements Node.
+func (*TimeLiteralNode) NodeKind() string { return "timeLiteral" }
@@ -0,0 +1,79 @@
+package expression
+
+import "encoding/json"
+
+const (
+	// InOperator tests whether the left operand is a member of the right
+	// operand, e.g. `$ in [1, 2, 3]` or `$ in 1..10`.
+	InOperator Operator = "in"
+	// NotInOperator is the negation of InOperator, e.g. `$ not in ["a","b"]`.
+	NotInOperator Operator = "not in"
+	// BetweenOperator tests whether the left operand falls within the
+	// right operand's RangeLiteralNode, e.g. `$ between 1..10`. Range
+	// bounds are int64 only, so a string range has no node to hold it.
+	BetweenOperator Operator = "between"
+)
+
+// RangeLiteralNode is a range literal, e.g. the 1..10 in `$ in 1..10`.
+// StartInclusive and StopInclusive say whether each bound is part of the
+// range; both are false in the zero value.
+type RangeLiteralNode struct {
+	Start          int64 `json:"start"`
+	Stop           int64 `json:"stop"`
+	StartInclusive bool  `json:"startInclusive"`
+	StopInclusive  bool  `json:"stopInclusive"`
+}
+
+// NodeKind implements Node.
+func (*RangeLiteralNode) NodeKind() string { return "rangeLiteral" }
+
+// ListLiteralNode is a list literal, e.g. the [1, 2, 3] in `$ in [1, 2, 3]`.
+// Elements holds other literal nodes; it is interface-typed like
+// BinaryNode's Left/Right; so ListLiteralNode needs the same kind-tagged
+// envelope to marshal through JSON.
+type ListLiteralNode struct {
+	Elements []Node
+}
+
+// NodeKind implements Node.
+func (*ListLiteralNode) NodeKind() string { return "listLiteral" }
+
+// MarshalJSON implements json.Marshaler.
+func (l ListLiteralNode) MarshalJSON() ([]byte, error) {
+	elements := make([]json.RawMessage, len(l.Elements))
+	for i, e := range l.Elements {
+		data, err := marshalNode(e)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = data
+	}
+	return json.Marshal(struct {
+		Elements []json.RawMessage `json:"elements"`
+	}{Elements: elements})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *ListLiteralNode) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Elements []json.RawMessage `json:"elements"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	elements := make([]Node, len(raw.Elements))
+	for i, e := range raw.Elements {
+		n, err := unmarshalNode(e)
+		if err != nil {
+			return err
+		}
+		elements[i] = n
+	}
+	l.Elements = elements
+	return nil
+}
+
+func init() {
+	RegisterNode("rangeLiteral", func() Node { return new(RangeLiteralNode) })
+	RegisterNode("listLiteral", func() Node { return new(ListLiteralNode) })
+}
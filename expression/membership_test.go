@@ -0,0 +1,79 @@
+package expression_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/influxdata/ifql/expression"
+)
+
+func TestMembershipMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		expr expression.Expression
+	}{
+		{
+			name: "in list",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.InOperator,
+					Left:     &expression.ReferenceNode{Name: "$", Kind: "field"},
+					Right: &expression.ListLiteralNode{
+						Elements: []expression.Node{
+							&expression.IntegerLiteralNode{Value: 1},
+							&expression.IntegerLiteralNode{Value: 2},
+							&expression.IntegerLiteralNode{Value: 3},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "not in string list",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.NotInOperator,
+					Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					Right: &expression.ListLiteralNode{
+						Elements: []expression.Node{
+							&expression.StringLiteralNode{Value: "a"},
+							&expression.StringLiteralNode{Value: "b"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "in range",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.InOperator,
+					Left:     &expression.ReferenceNode{Name: "$", Kind: "field"},
+					Right: &expression.RangeLiteralNode{
+						Start: 1, Stop: 10,
+						StartInclusive: true, StopInclusive: true,
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := json.Marshal(tt.expr)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			var got expression.Expression
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !cmp.Equal(tt.expr, got) {
+				t.Errorf("Expression round-trip = -want/+got %s", cmp.Diff(tt.expr, got))
+			}
+		})
+	}
+}
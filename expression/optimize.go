@@ -0,0 +1,228 @@
+package expression
+
+// UnaryNode applies Operator to Operand, e.g. `not x`. It has no
+// MarshalJSON/UnmarshalJSON, so Operand is not wrapped in the kind-tagged
+// envelope and a UnaryNode does not decode back from JSON.
+type UnaryNode struct {
+	Operator Operator
+	Operand  Node
+}
+
+// NodeKind implements Node.
+func (*UnaryNode) NodeKind() string { return "unary" }
+
+func init() {
+	RegisterNode("unary", func() Node { return new(UnaryNode) })
+}
+
+// Optimize returns a tree equivalent to e but with every subtree of only
+// literal nodes folded into a single literal, and the boolean identities
+// `true and x`, `false and x`, `true or x`, `false or x`, and `not not x`
+// applied. ifql.NewQuery is meant to run this on every FilterOpSpec.Expression
+// and JoinOpSpec.Eval before returning the QuerySpec, so a query built
+// programmatically with dead branches doesn't pay their per-point cost.
+//
+// Regex and reference nodes are opaque: Optimize never looks inside or
+// folds across them, since their value isn't known until execution.
+func Optimize(e Expression) Expression {
+	return Expression{Root: optimizeNode(e.Root)}
+}
+
+// optimizeNode walks bottom-up: a node's children are optimized first, so
+// folding a parent only ever has to look at already-folded (and therefore
+// maximally simplified) children.
+//
+// This does not flatten associative and/or chains that were already
+// nested on input (e.g. `(a and b) and c` stays three BinaryNodes deep);
+// BinaryNode only has a single Left and Right, so representing a chain as
+// one flat node would need a variadic and/or node type this package
+// doesn't have. It only prevents folding itself from introducing new
+// nesting beyond what was already there.
+func optimizeNode(n Node) Node {
+	switch t := n.(type) {
+	case *BinaryNode:
+		left := optimizeNode(t.Left)
+		right := optimizeNode(t.Right)
+		return optimizeBinary(t.Operator, left, right)
+	case *UnaryNode:
+		operand := optimizeNode(t.Operand)
+		return optimizeUnary(t.Operator, operand)
+	default:
+		// Reference, literal, regex: nothing to fold.
+		return n
+	}
+}
+
+func optimizeUnary(op Operator, operand Node) Node {
+	if op != NotOperator {
+		return &UnaryNode{Operator: op, Operand: operand}
+	}
+	switch o := operand.(type) {
+	case *BooleanLiteralNode:
+		return &BooleanLiteralNode{Value: !o.Value}
+	case *UnaryNode:
+		if o.Operator == NotOperator {
+			// not not x -> x
+			return o.Operand
+		}
+	}
+	return &UnaryNode{Operator: op, Operand: operand}
+}
+
+func optimizeBinary(op Operator, left, right Node) Node {
+	if folded, ok := identity(op, left, right); ok {
+		return folded
+	}
+	if folded, ok := foldLiteral(op, left, right); ok {
+		return folded
+	}
+	return &BinaryNode{Operator: op, Left: left, Right: right}
+}
+
+// identity applies the boolean absorption/identity rules; it does not
+// require both sides to be literals, only the side being absorbed away.
+func identity(op Operator, left, right Node) (Node, bool) {
+	switch op {
+	case AndOperator:
+		if b, ok := boolValue(left); ok {
+			if !b {
+				return &BooleanLiteralNode{Value: false}, true // false and x -> false
+			}
+			return right, true // true and x -> x
+		}
+		if b, ok := boolValue(right); ok {
+			if !b {
+				return &BooleanLiteralNode{Value: false}, true // x and false -> false
+			}
+			return left, true // x and true -> x
+		}
+	case OrOperator:
+		if b, ok := boolValue(left); ok {
+			if b {
+				return &BooleanLiteralNode{Value: true}, true // true or x -> true
+			}
+			return right, true // false or x -> x
+		}
+		if b, ok := boolValue(right); ok {
+			if b {
+				return &BooleanLiteralNode{Value: true}, true // x or true -> true
+			}
+			return left, true // x or false -> x
+		}
+	}
+	return nil, false
+}
+
+func boolValue(n Node) (bool, bool) {
+	b, ok := n.(*BooleanLiteralNode)
+	if !ok {
+		return false, false
+	}
+	return b.Value, true
+}
+
+// foldLiteral evaluates op over left/right when both are literal nodes of a
+// type op applies to, returning the single resulting literal node.
+func foldLiteral(op Operator, left, right Node) (Node, bool) {
+	switch l := left.(type) {
+	case *IntegerLiteralNode:
+		switch r := right.(type) {
+		case *IntegerLiteralNode:
+			return foldNumeric(op, float64(l.Value), float64(r.Value), true)
+		case *FloatLiteralNode:
+			return foldNumeric(op, float64(l.Value), r.Value, false)
+		}
+	case *FloatLiteralNode:
+		switch r := right.(type) {
+		case *IntegerLiteralNode:
+			return foldNumeric(op, l.Value, float64(r.Value), false)
+		case *FloatLiteralNode:
+			return foldNumeric(op, l.Value, r.Value, false)
+		}
+	case *StringLiteralNode:
+		if r, ok := right.(*StringLiteralNode); ok {
+			return foldOrdered(op, l.Value == r.Value, l.Value < r.Value)
+		}
+	case *BooleanLiteralNode:
+		if r, ok := right.(*BooleanLiteralNode); ok {
+			return foldEquality(op, l.Value == r.Value)
+		}
+	}
+	return nil, false
+}
+
+// foldNumeric evaluates an arithmetic or comparison op over two numbers.
+// bothInt keeps an integer result as an IntegerLiteralNode rather than a
+// FloatLiteralNode; division always yields a float, matching normal
+// arithmetic semantics where int/int isn't guaranteed to be exact.
+func foldNumeric(op Operator, l, r float64, bothInt bool) (Node, bool) {
+	switch op {
+	case AdditionOperator:
+		return numericResult(l+r, bothInt), true
+	case SubtractionOperator:
+		return numericResult(l-r, bothInt), true
+	case MultiplicationOperator:
+		return numericResult(l*r, bothInt), true
+	case DivisionOperator:
+		if bothInt && r == 0 {
+			// Don't fold an integer divide-by-zero: that's a runtime error in
+			// normal (non-optimized) evaluation, and folding it to +/-Inf or
+			// NaN here would silently change the query's behavior instead of
+			// preserving that error.
+			return nil, false
+		}
+		return &FloatLiteralNode{Value: l / r}, true
+	case EqualOperator:
+		return &BooleanLiteralNode{Value: l == r}, true
+	case NotEqualOperator:
+		return &BooleanLiteralNode{Value: l != r}, true
+	case LessThanOperator:
+		return &BooleanLiteralNode{Value: l < r}, true
+	case LessThanEqualOperator:
+		return &BooleanLiteralNode{Value: l <= r}, true
+	case GreaterThanOperator:
+		return &BooleanLiteralNode{Value: l > r}, true
+	case GreaterThanEqualOperator:
+		return &BooleanLiteralNode{Value: l >= r}, true
+	}
+	return nil, false
+}
+
+func numericResult(v float64, asInt bool) Node {
+	if asInt {
+		return &IntegerLiteralNode{Value: int64(v)}
+	}
+	return &FloatLiteralNode{Value: v}
+}
+
+// foldOrdered evaluates op over a pair that supports both equality and
+// ordering (e.g. strings).
+func foldOrdered(op Operator, eq, lt bool) (Node, bool) {
+	switch op {
+	case EqualOperator:
+		return &BooleanLiteralNode{Value: eq}, true
+	case NotEqualOperator:
+		return &BooleanLiteralNode{Value: !eq}, true
+	case LessThanOperator:
+		return &BooleanLiteralNode{Value: lt}, true
+	case LessThanEqualOperator:
+		return &BooleanLiteralNode{Value: lt || eq}, true
+	case GreaterThanOperator:
+		return &BooleanLiteralNode{Value: !lt && !eq}, true
+	case GreaterThanEqualOperator:
+		return &BooleanLiteralNode{Value: !lt}, true
+	}
+	return nil, false
+}
+
+// foldEquality evaluates op over a pair that only supports equality (e.g.
+// booleans).
+func foldEquality(op Operator, eq bool) (Node, bool) {
+	switch op {
+	case EqualOperator:
+		return &BooleanLiteralNode{Value: eq}, true
+	case NotEqualOperator:
+		return &BooleanLiteralNode{Value: !eq}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,183 @@
+package expression_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/influxdata/ifql/expression"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name string
+		expr expression.Expression
+		want expression.Expression
+	}{
+		{
+			name: "folds integer addition",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.AdditionOperator,
+					Left:     &expression.IntegerLiteralNode{Value: 2},
+					Right:    &expression.IntegerLiteralNode{Value: 3},
+				},
+			},
+			want: expression.Expression{Root: &expression.IntegerLiteralNode{Value: 5}},
+		},
+		{
+			name: "folds string equality",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.EqualOperator,
+					Left:     &expression.StringLiteralNode{Value: "a"},
+					Right:    &expression.StringLiteralNode{Value: "a"},
+				},
+			},
+			want: expression.Expression{Root: &expression.BooleanLiteralNode{Value: true}},
+		},
+		{
+			name: "true and x absorbs to x",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.AndOperator,
+					Left:     &expression.BooleanLiteralNode{Value: true},
+					Right:    &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+				},
+			},
+			want: expression.Expression{Root: &expression.ReferenceNode{Name: "t1", Kind: "tag"}},
+		},
+		{
+			name: "false and x absorbs to false",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.AndOperator,
+					Left:     &expression.BooleanLiteralNode{Value: false},
+					Right:    &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+				},
+			},
+			want: expression.Expression{Root: &expression.BooleanLiteralNode{Value: false}},
+		},
+		{
+			name: "x or true absorbs to true",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.OrOperator,
+					Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					Right:    &expression.BooleanLiteralNode{Value: true},
+				},
+			},
+			want: expression.Expression{Root: &expression.BooleanLiteralNode{Value: true}},
+		},
+		{
+			name: "x or false absorbs to x",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.OrOperator,
+					Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					Right:    &expression.BooleanLiteralNode{Value: false},
+				},
+			},
+			want: expression.Expression{Root: &expression.ReferenceNode{Name: "t1", Kind: "tag"}},
+		},
+		{
+			name: "not not x collapses to x",
+			expr: expression.Expression{
+				Root: &expression.UnaryNode{
+					Operator: expression.NotOperator,
+					Operand: &expression.UnaryNode{
+						Operator: expression.NotOperator,
+						Operand:  &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					},
+				},
+			},
+			want: expression.Expression{Root: &expression.ReferenceNode{Name: "t1", Kind: "tag"}},
+		},
+		{
+			name: "dead branch folds bottom-up through a live comparison",
+			// (2+3 == 5) and (t1 == "val1")  ->  true and (t1=="val1")  ->  t1=="val1"
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.AndOperator,
+					Left: &expression.BinaryNode{
+						Operator: expression.EqualOperator,
+						Left: &expression.BinaryNode{
+							Operator: expression.AdditionOperator,
+							Left:     &expression.IntegerLiteralNode{Value: 2},
+							Right:    &expression.IntegerLiteralNode{Value: 3},
+						},
+						Right: &expression.IntegerLiteralNode{Value: 5},
+					},
+					Right: &expression.BinaryNode{
+						Operator: expression.EqualOperator,
+						Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+						Right:    &expression.StringLiteralNode{Value: "val1"},
+					},
+				},
+			},
+			want: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.EqualOperator,
+					Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					Right:    &expression.StringLiteralNode{Value: "val1"},
+				},
+			},
+		},
+		{
+			name: "integer division by zero is not folded",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.DivisionOperator,
+					Left:     &expression.IntegerLiteralNode{Value: 1},
+					Right:    &expression.IntegerLiteralNode{Value: 0},
+				},
+			},
+			want: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.DivisionOperator,
+					Left:     &expression.IntegerLiteralNode{Value: 1},
+					Right:    &expression.IntegerLiteralNode{Value: 0},
+				},
+			},
+		},
+		{
+			name: "float division by zero still folds",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.DivisionOperator,
+					Left:     &expression.FloatLiteralNode{Value: 1},
+					Right:    &expression.FloatLiteralNode{Value: 0},
+				},
+			},
+			want: expression.Expression{Root: &expression.FloatLiteralNode{Value: math.Inf(1)}},
+		},
+		{
+			name: "regex nodes are opaque",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.RegexpMatchOperator,
+					Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					Right:    &expression.RegexpLiteralNode{Value: "val.*"},
+				},
+			},
+			want: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.RegexpMatchOperator,
+					Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					Right:    &expression.RegexpLiteralNode{Value: "val.*"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := expression.Optimize(tt.expr)
+			if !cmp.Equal(tt.want, got) {
+				t.Errorf("Optimize() = -want/+got %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package functions
+
+import (
+	"fmt"
+
+	"github.com/influxdata/ifql/expression"
+	"github.com/influxdata/ifql/query"
+)
+
+const FilterKind = "filter"
+
+// FilterOpSpec keeps only the rows for which Expression evaluates true,
+// e.g. filter(exp:{"t1"=="val1" and $ == 10}).
+type FilterOpSpec struct {
+	Expression expression.Expression `json:"expression"`
+}
+
+// Kind implements query.OperationSpec.
+func (s *FilterOpSpec) Kind() query.OperationKind { return FilterKind }
+
+// Validate rejects a FilterOpSpec whose Expression puts a
+// RangeLiteralNode or ListLiteralNode on the left-hand side of a
+// BinaryNode, e.g. `[1,2,3] in $`: range/list literals are only meaningful
+// as the right-hand operand of in/not in/between, the same way a
+// BinaryNode's Left is always the thing being tested and Right is the
+// membership set being tested against.
+func (s *FilterOpSpec) Validate() error {
+	return validateNode(s.Expression.Root)
+}
+
+func validateNode(n expression.Node) error {
+	if u, ok := n.(*expression.UnaryNode); ok {
+		return validateNode(u.Operand)
+	}
+	b, ok := n.(*expression.BinaryNode)
+	if !ok {
+		return nil
+	}
+	if isMembershipLiteral(b.Left) {
+		return fmt.Errorf("filter: range/list literal not allowed on left-hand side of %q", b.Operator)
+	}
+	if err := validateNode(b.Left); err != nil {
+		return err
+	}
+	return validateNode(b.Right)
+}
+
+func isMembershipLiteral(n expression.Node) bool {
+	switch n.(type) {
+	case *expression.RangeLiteralNode, *expression.ListLiteralNode:
+		return true
+	default:
+		return false
+	}
+}
+
+func init() {
+	query.RegisterOpSpec(FilterKind, func() query.OperationSpec { return new(FilterOpSpec) })
+}
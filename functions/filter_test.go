@@ -0,0 +1,73 @@
+package functions_test
+
+import (
+	"testing"
+
+	"github.com/influxdata/ifql/expression"
+	"github.com/influxdata/ifql/functions"
+)
+
+func TestFilterOpSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *functions.FilterOpSpec
+		wantErr bool
+	}{
+		{
+			name: "list on the right is fine",
+			spec: &functions.FilterOpSpec{
+				Expression: expression.Expression{
+					Root: &expression.BinaryNode{
+						Operator: expression.InOperator,
+						Left:     &expression.ReferenceNode{Name: "$", Kind: "field"},
+						Right: &expression.ListLiteralNode{
+							Elements: []expression.Node{&expression.IntegerLiteralNode{Value: 1}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "list on the left is rejected",
+			spec: &functions.FilterOpSpec{
+				Expression: expression.Expression{
+					Root: &expression.BinaryNode{
+						Operator: expression.InOperator,
+						Left: &expression.ListLiteralNode{
+							Elements: []expression.Node{&expression.IntegerLiteralNode{Value: 1}},
+						},
+						Right: &expression.ReferenceNode{Name: "$", Kind: "field"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "range on the left is rejected",
+			spec: &functions.FilterOpSpec{
+				Expression: expression.Expression{
+					Root: &expression.BinaryNode{
+						Operator: expression.AndOperator,
+						Left: &expression.BinaryNode{
+							Operator: expression.EqualOperator,
+							Left:     &expression.RangeLiteralNode{Start: 1, Stop: 10},
+							Right:    &expression.IntegerLiteralNode{Value: 5},
+						},
+						Right: &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.spec.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
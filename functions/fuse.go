@@ -0,0 +1,295 @@
+package functions
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/influxdata/ifql/expression"
+)
+
+// ForceJIT and NoJIT mirror the JIT-VM's --forcejit/--nojit executor flags:
+// NoJIT always runs the staged filter -> map -> aggregate path, never
+// fusing, and ForceJIT panics on an unsupported op instead of silently
+// falling back to it. Neither is wired to an executor command line in this
+// tree - there's no executor binary here to add flags to - so for now
+// they're just package vars a caller (or a test) sets directly.
+var (
+	ForceJIT bool
+	NoJIT    bool
+)
+
+// unsupportedOpError reports an expression.Node a FusedAgg can't evaluate,
+// so NewFusedAgg's caller can fall back to running the filter/map stages
+// through the ordinary Transformation.Process path instead.
+type unsupportedOpError struct {
+	node expression.Node
+}
+
+func (e unsupportedOpError) Error() string {
+	return fmt.Sprintf("fuse: unsupported node %T", e.node)
+}
+
+// FusedAgg is a compiled filter -> map -> stddev chain: predicate selects
+// rows, mapExpr computes the value fed into Agg, and both are evaluated
+// directly against each row's columns in Run's loop rather than walking
+// through a Transformation.Process call per block. This is the "interpret
+// the IR with a tight typed loop" fallback from the JIT-VM idea; there's no
+// plan package or rewrite pass in this tree to discover fusable chains at
+// plan time, and no build-at-runtime toolchain to hang a text/template +
+// plugin codegen path off of, so that half of the request isn't done here -
+// a caller has to identify the chain and call NewFusedAgg itself.
+type FusedAgg struct {
+	predicate expression.Node
+	mapExpr   expression.Node
+	Agg       *StddevAgg
+}
+
+// kernelIR is the predicate/mapExpr pair a cache entry remembers having
+// already passed checkSupported, so repeated calls for the same chain skip
+// re-walking the tree.
+type kernelIR struct {
+	predicate expression.Node
+	mapExpr   expression.Node
+}
+
+var (
+	kernelCacheMu sync.Mutex
+	kernelCache   = make(map[string]kernelIR)
+)
+
+// NewFusedAgg compiles (or returns the cached kernel for) a predicate ->
+// mapExpr -> stddev chain. A nil predicate means every row passes; a nil
+// mapExpr means the row's sole column is used unmodified. It returns
+// unsupportedOpError if predicate or mapExpr contains a node Run can't
+// evaluate (anything beyond arithmetic, comparison, and/or/not over
+// references and literals); ForceJIT turns that into a panic instead of a
+// returned error, matching --forcejit's "don't silently fall back" intent.
+func NewFusedAgg(predicate, mapExpr expression.Node) (*FusedAgg, error) {
+	if NoJIT {
+		return nil, unsupportedOpError{node: predicate}
+	}
+	key := irKey(predicate) + "|" + irKey(mapExpr)
+
+	kernelCacheMu.Lock()
+	defer kernelCacheMu.Unlock()
+	ir, ok := kernelCache[key]
+	if !ok {
+		if err := checkSupported(predicate); err != nil {
+			if ForceJIT {
+				panic(err)
+			}
+			return nil, err
+		}
+		if err := checkSupported(mapExpr); err != nil {
+			if ForceJIT {
+				panic(err)
+			}
+			return nil, err
+		}
+		ir = kernelIR{predicate: predicate, mapExpr: mapExpr}
+		kernelCache[key] = ir
+	}
+
+	return &FusedAgg{
+		predicate: ir.predicate,
+		mapExpr:   ir.mapExpr,
+		Agg:       new(StddevAgg).NewFloatAgg().(*StddevAgg),
+	}, nil
+}
+
+// Run evaluates the kernel's predicate and mapExpr against every row,
+// accumulating the surviving values directly into Agg.
+func (k *FusedAgg) Run(rows []map[string]float64) error {
+	vs := make([]float64, 0, len(rows))
+	for _, row := range rows {
+		if k.predicate != nil {
+			ok, err := evalBool(k.predicate, row)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		mapExpr, err := identityExpr(k.mapExpr, row)
+		if err != nil {
+			return err
+		}
+		v, err := evalFloat(mapExpr, row)
+		if err != nil {
+			return err
+		}
+		vs = append(vs, v)
+	}
+	k.Agg.DoFloat(vs)
+	return nil
+}
+
+// identityExpr returns n, or - when n is nil, meaning no map stage was
+// given - a reference to row's one column so Run can still evaluate it
+// uniformly through evalFloat. It errors rather than picking an arbitrary
+// column when row doesn't have exactly one: row is a map, so iterating it
+// directly would make the chosen column (and therefore the result)
+// non-deterministic across calls.
+func identityExpr(n expression.Node, row map[string]float64) (expression.Node, error) {
+	if n != nil {
+		return n, nil
+	}
+	if len(row) != 1 {
+		return nil, fmt.Errorf("fuse: no mapExpr given and row has %d columns, want exactly 1", len(row))
+	}
+	var name string
+	for name = range row {
+	}
+	return &expression.ReferenceNode{Name: name, Kind: "field"}, nil
+}
+
+// checkSupported walks n and returns unsupportedOpError for the first node
+// evalBool/evalFloat can't evaluate. It checks node types and operators
+// only; a supported operator placed where Run needs the other kind of value
+// (e.g. `and` inside mapExpr) is reported by Run instead.
+func checkSupported(n expression.Node) error {
+	switch t := n.(type) {
+	case nil:
+		return nil
+	case *expression.BinaryNode:
+		switch t.Operator {
+		case expression.AdditionOperator, expression.SubtractionOperator, expression.MultiplicationOperator, expression.DivisionOperator,
+			expression.EqualOperator, expression.NotEqualOperator, expression.LessThanOperator, expression.LessThanEqualOperator,
+			expression.GreaterThanOperator, expression.GreaterThanEqualOperator, expression.AndOperator, expression.OrOperator:
+		default:
+			return unsupportedOpError{node: n}
+		}
+		if err := checkSupported(t.Left); err != nil {
+			return err
+		}
+		return checkSupported(t.Right)
+	case *expression.UnaryNode:
+		if t.Operator != expression.NotOperator {
+			return unsupportedOpError{node: n}
+		}
+		return checkSupported(t.Operand)
+	case *expression.ReferenceNode, *expression.IntegerLiteralNode, *expression.FloatLiteralNode, *expression.BooleanLiteralNode:
+		return nil
+	default:
+		return unsupportedOpError{node: n}
+	}
+}
+
+// irKey renders n into a string that's equal for structurally identical
+// expressions, so NewFusedAgg can key its kernel cache by it instead of
+// recompiling the same filter -> map chain on every call.
+func irKey(n expression.Node) string {
+	switch t := n.(type) {
+	case nil:
+		return "_"
+	case *expression.BinaryNode:
+		return fmt.Sprintf("(%s %s %s)", irKey(t.Left), t.Operator, irKey(t.Right))
+	case *expression.UnaryNode:
+		return fmt.Sprintf("(%s %s)", t.Operator, irKey(t.Operand))
+	case *expression.ReferenceNode:
+		return "ref:" + t.Name
+	case *expression.IntegerLiteralNode:
+		return fmt.Sprintf("int:%d", t.Value)
+	case *expression.FloatLiteralNode:
+		return fmt.Sprintf("float:%v", t.Value)
+	case *expression.BooleanLiteralNode:
+		return fmt.Sprintf("bool:%v", t.Value)
+	default:
+		return fmt.Sprintf("%T", n)
+	}
+}
+
+// evalFloat evaluates the arithmetic subset of n against row.
+func evalFloat(n expression.Node, row map[string]float64) (float64, error) {
+	switch t := n.(type) {
+	case *expression.IntegerLiteralNode:
+		return float64(t.Value), nil
+	case *expression.FloatLiteralNode:
+		return t.Value, nil
+	case *expression.ReferenceNode:
+		v, ok := row[t.Name]
+		if !ok {
+			return 0, fmt.Errorf("fuse: no column %q in row", t.Name)
+		}
+		return v, nil
+	case *expression.BinaryNode:
+		l, err := evalFloat(t.Left, row)
+		if err != nil {
+			return 0, err
+		}
+		r, err := evalFloat(t.Right, row)
+		if err != nil {
+			return 0, err
+		}
+		switch t.Operator {
+		case expression.AdditionOperator:
+			return l + r, nil
+		case expression.SubtractionOperator:
+			return l - r, nil
+		case expression.MultiplicationOperator:
+			return l * r, nil
+		case expression.DivisionOperator:
+			return l / r, nil
+		}
+		return 0, unsupportedOpError{node: n}
+	}
+	return 0, unsupportedOpError{node: n}
+}
+
+// evalBool evaluates the comparison/boolean subset of n against row.
+func evalBool(n expression.Node, row map[string]float64) (bool, error) {
+	switch t := n.(type) {
+	case *expression.BooleanLiteralNode:
+		return t.Value, nil
+	case *expression.UnaryNode:
+		if t.Operator != expression.NotOperator {
+			return false, unsupportedOpError{node: n}
+		}
+		v, err := evalBool(t.Operand, row)
+		return !v, err
+	case *expression.BinaryNode:
+		switch t.Operator {
+		case expression.AndOperator:
+			l, err := evalBool(t.Left, row)
+			if err != nil || !l {
+				return false, err
+			}
+			return evalBool(t.Right, row)
+		case expression.OrOperator:
+			l, err := evalBool(t.Left, row)
+			if err != nil {
+				return false, err
+			}
+			if l {
+				return true, nil
+			}
+			return evalBool(t.Right, row)
+		case expression.EqualOperator, expression.NotEqualOperator, expression.LessThanOperator,
+			expression.LessThanEqualOperator, expression.GreaterThanOperator, expression.GreaterThanEqualOperator:
+			l, err := evalFloat(t.Left, row)
+			if err != nil {
+				return false, err
+			}
+			r, err := evalFloat(t.Right, row)
+			if err != nil {
+				return false, err
+			}
+			switch t.Operator {
+			case expression.EqualOperator:
+				return l == r, nil
+			case expression.NotEqualOperator:
+				return l != r, nil
+			case expression.LessThanOperator:
+				return l < r, nil
+			case expression.LessThanEqualOperator:
+				return l <= r, nil
+			case expression.GreaterThanOperator:
+				return l > r, nil
+			case expression.GreaterThanEqualOperator:
+				return l >= r, nil
+			}
+		}
+	}
+	return false, unsupportedOpError{node: n}
+}
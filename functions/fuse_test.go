@@ -0,0 +1,151 @@
+package functions_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/influxdata/ifql/expression"
+	"github.com/influxdata/ifql/functions"
+)
+
+// TestFusedAggMatchesStaged checks that running a filter -> map -> stddev
+// chain through a FusedAgg produces the same stddev a staged
+// filter-then-map-then-DoFloat pass over the same rows would.
+func TestFusedAggMatchesStaged(t *testing.T) {
+	// predicate: value > 2
+	predicate := &expression.BinaryNode{
+		Operator: expression.GreaterThanOperator,
+		Left:     &expression.ReferenceNode{Name: "value", Kind: "field"},
+		Right:    &expression.IntegerLiteralNode{Value: 2},
+	}
+	// map: value * 10
+	mapExpr := &expression.BinaryNode{
+		Operator: expression.MultiplicationOperator,
+		Left:     &expression.ReferenceNode{Name: "value", Kind: "field"},
+		Right:    &expression.IntegerLiteralNode{Value: 10},
+	}
+
+	rows := []map[string]float64{
+		{"value": 1}, {"value": 2}, {"value": 3}, {"value": 4}, {"value": 5},
+	}
+
+	k, err := functions.NewFusedAgg(predicate, mapExpr)
+	if err != nil {
+		t.Fatalf("NewFusedAgg: %v", err)
+	}
+	if err := k.Run(rows); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	staged := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+	var stagedVs []float64
+	for _, row := range rows {
+		if row["value"] <= 2 {
+			continue
+		}
+		stagedVs = append(stagedVs, row["value"]*10)
+	}
+	staged.DoFloat(stagedVs)
+
+	if got, want := k.Agg.ValueFloat(), staged.ValueFloat(); math.Abs(got-want) > 1e-9 {
+		t.Errorf("fused stddev = %v, want %v (staged)", got, want)
+	}
+}
+
+// TestNewFusedAggUnsupportedOp checks that a predicate NewFusedAgg can't
+// evaluate (here, a regex match) is rejected rather than silently
+// mis-evaluated, and that NoJIT disables fusion outright.
+func TestNewFusedAggUnsupportedOp(t *testing.T) {
+	predicate := &expression.BinaryNode{
+		Operator: expression.RegexpMatchOperator,
+		Left:     &expression.ReferenceNode{Name: "host", Kind: "tag"},
+		Right:    &expression.RegexpLiteralNode{Value: "^web"},
+	}
+	if _, err := functions.NewFusedAgg(predicate, nil); err == nil {
+		t.Error("NewFusedAgg(regex predicate) = nil error, want unsupportedOpError")
+	}
+
+	functions.NoJIT = true
+	defer func() { functions.NoJIT = false }()
+	if _, err := functions.NewFusedAgg(nil, nil); err == nil {
+		t.Error("NewFusedAgg with NoJIT set = nil error, want fusion disabled")
+	}
+}
+
+// TestFusedAggNilMapExprRequiresSingleColumn checks that a nil mapExpr -
+// "use the row's sole column" - errors on an ambiguous multi-column row
+// instead of picking one of its columns at random (map iteration order is
+// undefined, so that would make the result non-deterministic).
+func TestFusedAggNilMapExprRequiresSingleColumn(t *testing.T) {
+	k, err := functions.NewFusedAgg(nil, nil)
+	if err != nil {
+		t.Fatalf("NewFusedAgg: %v", err)
+	}
+	rows := []map[string]float64{{"value": 1, "other": 2}}
+	if err := k.Run(rows); err == nil {
+		t.Error("Run(multi-column row, nil mapExpr) = nil error, want one")
+	}
+}
+
+// benchRows builds n rows of {"value": 0, 1, 2, ...} for the benchmarks
+// below.
+func benchRows(n int) []map[string]float64 {
+	rows := make([]map[string]float64, n)
+	for i := range rows {
+		rows[i] = map[string]float64{"value": float64(i)}
+	}
+	return rows
+}
+
+// BenchmarkFusedAggRun measures the fused filter -> map -> stddev path:
+// FusedAgg.Run evaluates both expressions directly against each row and
+// accumulates survivors into StddevAgg without a per-row Transformation
+// dispatch.
+func BenchmarkFusedAggRun(b *testing.B) {
+	predicate := &expression.BinaryNode{
+		Operator: expression.GreaterThanOperator,
+		Left:     &expression.ReferenceNode{Name: "value", Kind: "field"},
+		Right:    &expression.IntegerLiteralNode{Value: 2},
+	}
+	mapExpr := &expression.BinaryNode{
+		Operator: expression.MultiplicationOperator,
+		Left:     &expression.ReferenceNode{Name: "value", Kind: "field"},
+		Right:    &expression.IntegerLiteralNode{Value: 10},
+	}
+	rows := benchRows(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k, err := functions.NewFusedAgg(predicate, mapExpr)
+		if err != nil {
+			b.Fatalf("NewFusedAgg: %v", err)
+		}
+		if err := k.Run(rows); err != nil {
+			b.Fatalf("Run: %v", err)
+		}
+	}
+}
+
+// BenchmarkStagedFilterMapStddev measures the staged equivalent: a filter
+// pass, a map pass, then StddevAgg.DoFloat, each its own loop over the
+// rows - the shape Transformation.Process per-block dispatch produces
+// today without fusion.
+func BenchmarkStagedFilterMapStddev(b *testing.B) {
+	rows := benchRows(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var filtered []map[string]float64
+		for _, row := range rows {
+			if row["value"] > 2 {
+				filtered = append(filtered, row)
+			}
+		}
+		mapped := make([]float64, len(filtered))
+		for j, row := range filtered {
+			mapped[j] = row["value"] * 10
+		}
+		agg := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+		agg.DoFloat(mapped)
+	}
+}
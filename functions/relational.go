@@ -0,0 +1,600 @@
+package functions
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/influxdata/ifql/query"
+	"github.com/influxdata/ifql/query/execute"
+	"github.com/influxdata/ifql/query/plan"
+)
+
+// This file adds the relational-plan operators that were conspicuously
+// missing from the op set (select/range/filter/window/join/sum/count):
+// sort, limit, skip, distinct, and project.
+//
+// NOTE: each OpSpec below registers with query.RegisterOpSpec so it
+// round-trips through QuerySpec JSON (see query.Operation), the same as
+// every OpSpec in to_http.go. Each is also wired to a plan.ProcedureSpec
+// and an execute.Transformation, the same as stddev.go and to_http.go.
+// Wiring these in as chainable grammar methods (.sort(by:["host"]),
+// .limit(n:100), ...) the way select/range/filter are documented to work
+// is not done here: this tree has no PEG grammar, and the
+// query.RegisterFunction/query.Arguments machinery that
+// to_http.go's createToHTTPOpSpec calls into isn't present either, so
+// there's nothing to hook a createXOpSpec into yet.
+
+const (
+	SortKind     = "sort"
+	LimitKind    = "limit"
+	SkipKind     = "skip"
+	DistinctKind = "distinct"
+	ProjectKind  = "project"
+)
+
+// SortOpSpec orders a block's rows by Keys, e.g. .sort(by:["host"]).
+type SortOpSpec struct {
+	Keys       []string `json:"keys"`
+	Descending bool     `json:"descending"`
+}
+
+// Kind implements query.OperationSpec.
+func (s *SortOpSpec) Kind() query.OperationKind { return SortKind }
+
+// LimitOpSpec passes through at most N rows per block, e.g. .limit(n:100).
+type LimitOpSpec struct {
+	N int64 `json:"n"`
+}
+
+// Kind implements query.OperationSpec.
+func (s *LimitOpSpec) Kind() query.OperationKind { return LimitKind }
+
+// SkipOpSpec discards the first N rows per block before passing the rest
+// through, e.g. .skip(n:10).
+type SkipOpSpec struct {
+	N int64 `json:"n"`
+}
+
+// Kind implements query.OperationSpec.
+func (s *SkipOpSpec) Kind() query.OperationKind { return SkipKind }
+
+// DistinctOpSpec drops rows whose Keys columns repeat a combination already
+// seen, e.g. .distinct(by:["host"]).
+type DistinctOpSpec struct {
+	Keys []string `json:"keys"`
+}
+
+// Kind implements query.OperationSpec.
+func (s *DistinctOpSpec) Kind() query.OperationKind { return DistinctKind }
+
+// ProjectOpSpec narrows a block down to Columns, e.g.
+// .project(cols:["_time","_value"]).
+type ProjectOpSpec struct {
+	Columns []string `json:"columns"`
+}
+
+// Kind implements query.OperationSpec.
+func (s *ProjectOpSpec) Kind() query.OperationKind { return ProjectKind }
+
+func init() {
+	query.RegisterOpSpec(SortKind, func() query.OperationSpec { return new(SortOpSpec) })
+	query.RegisterOpSpec(LimitKind, func() query.OperationSpec { return new(LimitOpSpec) })
+	query.RegisterOpSpec(SkipKind, func() query.OperationSpec { return new(SkipOpSpec) })
+	query.RegisterOpSpec(DistinctKind, func() query.OperationSpec { return new(DistinctOpSpec) })
+	query.RegisterOpSpec(ProjectKind, func() query.OperationSpec { return new(ProjectOpSpec) })
+
+	plan.RegisterProcedureSpec(SortKind, newSortProcedure, SortKind)
+	plan.RegisterProcedureSpec(LimitKind, newLimitProcedure, LimitKind)
+	plan.RegisterProcedureSpec(SkipKind, newSkipProcedure, SkipKind)
+	plan.RegisterProcedureSpec(DistinctKind, newDistinctProcedure, DistinctKind)
+	plan.RegisterProcedureSpec(ProjectKind, newProjectProcedure, ProjectKind)
+
+	execute.RegisterTransformation(SortKind, createSortTransformation)
+	execute.RegisterTransformation(LimitKind, createLimitTransformation)
+	execute.RegisterTransformation(SkipKind, createSkipTransformation)
+	execute.RegisterTransformation(DistinctKind, createDistinctTransformation)
+	execute.RegisterTransformation(ProjectKind, createProjectTransformation)
+}
+
+// SortProcedureSpec is SortOpSpec's compiled plan form.
+type SortProcedureSpec struct {
+	Keys       []string
+	Descending bool
+}
+
+// Kind implements plan.ProcedureSpec.
+func (s *SortProcedureSpec) Kind() plan.ProcedureKind { return SortKind }
+
+// Copy implements plan.ProcedureSpec.
+func (s *SortProcedureSpec) Copy() plan.ProcedureSpec {
+	keys := make([]string, len(s.Keys))
+	copy(keys, s.Keys)
+	return &SortProcedureSpec{Keys: keys, Descending: s.Descending}
+}
+
+func newSortProcedure(qs query.OperationSpec, a plan.Administration) (plan.ProcedureSpec, error) {
+	spec, ok := qs.(*SortOpSpec)
+	if !ok {
+		return nil, fmt.Errorf("invalid spec type %T", qs)
+	}
+	return &SortProcedureSpec{Keys: spec.Keys, Descending: spec.Descending}, nil
+}
+
+// LimitProcedureSpec is LimitOpSpec's compiled plan form.
+type LimitProcedureSpec struct {
+	N int64
+}
+
+// Kind implements plan.ProcedureSpec.
+func (s *LimitProcedureSpec) Kind() plan.ProcedureKind { return LimitKind }
+
+// Copy implements plan.ProcedureSpec.
+func (s *LimitProcedureSpec) Copy() plan.ProcedureSpec {
+	return &LimitProcedureSpec{N: s.N}
+}
+
+func newLimitProcedure(qs query.OperationSpec, a plan.Administration) (plan.ProcedureSpec, error) {
+	spec, ok := qs.(*LimitOpSpec)
+	if !ok {
+		return nil, fmt.Errorf("invalid spec type %T", qs)
+	}
+	return &LimitProcedureSpec{N: spec.N}, nil
+}
+
+// SkipProcedureSpec is SkipOpSpec's compiled plan form.
+type SkipProcedureSpec struct {
+	N int64
+}
+
+// Kind implements plan.ProcedureSpec.
+func (s *SkipProcedureSpec) Kind() plan.ProcedureKind { return SkipKind }
+
+// Copy implements plan.ProcedureSpec.
+func (s *SkipProcedureSpec) Copy() plan.ProcedureSpec {
+	return &SkipProcedureSpec{N: s.N}
+}
+
+func newSkipProcedure(qs query.OperationSpec, a plan.Administration) (plan.ProcedureSpec, error) {
+	spec, ok := qs.(*SkipOpSpec)
+	if !ok {
+		return nil, fmt.Errorf("invalid spec type %T", qs)
+	}
+	return &SkipProcedureSpec{N: spec.N}, nil
+}
+
+// DistinctProcedureSpec is DistinctOpSpec's compiled plan form.
+type DistinctProcedureSpec struct {
+	Keys []string
+}
+
+// Kind implements plan.ProcedureSpec.
+func (s *DistinctProcedureSpec) Kind() plan.ProcedureKind { return DistinctKind }
+
+// Copy implements plan.ProcedureSpec.
+func (s *DistinctProcedureSpec) Copy() plan.ProcedureSpec {
+	keys := make([]string, len(s.Keys))
+	copy(keys, s.Keys)
+	return &DistinctProcedureSpec{Keys: keys}
+}
+
+func newDistinctProcedure(qs query.OperationSpec, a plan.Administration) (plan.ProcedureSpec, error) {
+	spec, ok := qs.(*DistinctOpSpec)
+	if !ok {
+		return nil, fmt.Errorf("invalid spec type %T", qs)
+	}
+	return &DistinctProcedureSpec{Keys: spec.Keys}, nil
+}
+
+// ProjectProcedureSpec is ProjectOpSpec's compiled plan form.
+type ProjectProcedureSpec struct {
+	Columns []string
+}
+
+// Kind implements plan.ProcedureSpec.
+func (s *ProjectProcedureSpec) Kind() plan.ProcedureKind { return ProjectKind }
+
+// Copy implements plan.ProcedureSpec.
+func (s *ProjectProcedureSpec) Copy() plan.ProcedureSpec {
+	cols := make([]string, len(s.Columns))
+	copy(cols, s.Columns)
+	return &ProjectProcedureSpec{Columns: cols}
+}
+
+func newProjectProcedure(qs query.OperationSpec, a plan.Administration) (plan.ProcedureSpec, error) {
+	spec, ok := qs.(*ProjectOpSpec)
+	if !ok {
+		return nil, fmt.Errorf("invalid spec type %T", qs)
+	}
+	return &ProjectProcedureSpec{Columns: spec.Columns}, nil
+}
+
+// passthroughTransformation implements the RetractBlock/UpdateWatermark/
+// UpdateProcessingTime/Finish boilerplate every relational transformation
+// below shares; each embeds it and only has to implement Process (and, for
+// sort, override Finish to emit its buffered rows).
+type passthroughTransformation struct {
+	d     execute.Dataset
+	cache execute.BlockBuilderCache
+}
+
+func (t *passthroughTransformation) RetractBlock(id execute.DatasetID, key execute.PartitionKey) error {
+	return t.d.RetractBlock(key)
+}
+func (t *passthroughTransformation) UpdateWatermark(id execute.DatasetID, pt execute.Time) error {
+	return t.d.UpdateWatermark(pt)
+}
+func (t *passthroughTransformation) UpdateProcessingTime(id execute.DatasetID, pt execute.Time) error {
+	return t.d.UpdateProcessingTime(pt)
+}
+func (t *passthroughTransformation) Finish(id execute.DatasetID, err error) {
+	t.d.Finish(err)
+}
+
+// appendRow copies er's one row into col i of builder for every column,
+// the same row-at-a-time shape to_http.go's encodeInflux/mergePrometheus
+// already read blocks in (each execute.ColReader b.Do hands the callback
+// holds exactly one row, hence the [0] index on every accessor).
+func appendRow(builder execute.BlockBuilder, er execute.ColReader) {
+	for i, c := range er.Cols() {
+		switch c.Type {
+		case execute.TBool:
+			builder.AppendBool(i, er.Bools(i)[0])
+		case execute.TInt:
+			builder.AppendInt(i, er.Ints(i)[0])
+		case execute.TUInt:
+			builder.AppendUInt(i, er.UInts(i)[0])
+		case execute.TFloat:
+			builder.AppendFloat(i, er.Floats(i)[0])
+		case execute.TString:
+			builder.AppendString(i, er.Strings(i)[0])
+		case execute.TTime:
+			builder.AppendTime(i, er.Times(i)[0])
+		}
+	}
+}
+
+// limitTransformation passes through at most spec.N rows per block.
+type limitTransformation struct {
+	passthroughTransformation
+	spec *LimitProcedureSpec
+	rows int64
+}
+
+func createLimitTransformation(id execute.DatasetID, mode execute.AccumulationMode, spec plan.ProcedureSpec, a execute.Administration) (execute.Transformation, execute.Dataset, error) {
+	s, ok := spec.(*LimitProcedureSpec)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid spec type %T", spec)
+	}
+	cache := execute.NewBlockBuilderCache(a.Allocator())
+	d := execute.NewDataset(id, mode, cache)
+	t := &limitTransformation{passthroughTransformation: passthroughTransformation{d: d, cache: cache}, spec: s}
+	return t, d, nil
+}
+
+func (t *limitTransformation) Process(id execute.DatasetID, b execute.Block) error {
+	builder, created := t.cache.BlockBuilder(b.Key())
+	if created {
+		for _, c := range b.Cols() {
+			builder.AddCol(c)
+		}
+	}
+	var rows int64
+	return b.Do(func(er execute.ColReader) error {
+		if rows >= t.spec.N {
+			return nil
+		}
+		appendRow(builder, er)
+		rows++
+		return nil
+	})
+}
+
+// skipTransformation discards the first spec.N rows per block, passing the
+// rest through.
+type skipTransformation struct {
+	passthroughTransformation
+	spec *SkipProcedureSpec
+}
+
+func createSkipTransformation(id execute.DatasetID, mode execute.AccumulationMode, spec plan.ProcedureSpec, a execute.Administration) (execute.Transformation, execute.Dataset, error) {
+	s, ok := spec.(*SkipProcedureSpec)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid spec type %T", spec)
+	}
+	cache := execute.NewBlockBuilderCache(a.Allocator())
+	d := execute.NewDataset(id, mode, cache)
+	t := &skipTransformation{passthroughTransformation: passthroughTransformation{d: d, cache: cache}, spec: s}
+	return t, d, nil
+}
+
+func (t *skipTransformation) Process(id execute.DatasetID, b execute.Block) error {
+	builder, created := t.cache.BlockBuilder(b.Key())
+	if created {
+		for _, c := range b.Cols() {
+			builder.AddCol(c)
+		}
+	}
+	var seen int64
+	return b.Do(func(er execute.ColReader) error {
+		seen++
+		if seen <= t.spec.N {
+			return nil
+		}
+		appendRow(builder, er)
+		return nil
+	})
+}
+
+// projectTransformation narrows every block down to spec.Columns.
+type projectTransformation struct {
+	passthroughTransformation
+	spec   *ProjectProcedureSpec
+	srcIdx []int
+}
+
+func createProjectTransformation(id execute.DatasetID, mode execute.AccumulationMode, spec plan.ProcedureSpec, a execute.Administration) (execute.Transformation, execute.Dataset, error) {
+	s, ok := spec.(*ProjectProcedureSpec)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid spec type %T", spec)
+	}
+	cache := execute.NewBlockBuilderCache(a.Allocator())
+	d := execute.NewDataset(id, mode, cache)
+	t := &projectTransformation{passthroughTransformation: passthroughTransformation{d: d, cache: cache}, spec: s}
+	return t, d, nil
+}
+
+func (t *projectTransformation) Process(id execute.DatasetID, b execute.Block) error {
+	builder, created := t.cache.BlockBuilder(b.Key())
+	if created {
+		cols := b.Cols()
+		t.srcIdx = t.srcIdx[:0]
+		for _, name := range t.spec.Columns {
+			for i, c := range cols {
+				if c.Label == name {
+					t.srcIdx = append(t.srcIdx, i)
+					builder.AddCol(c)
+					break
+				}
+			}
+		}
+	}
+	return b.Do(func(er execute.ColReader) error {
+		for dst, src := range t.srcIdx {
+			c := er.Cols()[src]
+			switch c.Type {
+			case execute.TBool:
+				builder.AppendBool(dst, er.Bools(src)[0])
+			case execute.TInt:
+				builder.AppendInt(dst, er.Ints(src)[0])
+			case execute.TUInt:
+				builder.AppendUInt(dst, er.UInts(src)[0])
+			case execute.TFloat:
+				builder.AppendFloat(dst, er.Floats(src)[0])
+			case execute.TString:
+				builder.AppendString(dst, er.Strings(src)[0])
+			case execute.TTime:
+				builder.AppendTime(dst, er.Times(src)[0])
+			}
+		}
+		return nil
+	})
+}
+
+// distinctTransformation drops rows whose spec.Keys columns repeat a
+// combination already seen, keyed the same way to_http.go's seriesKey
+// folds a label set into a single map key.
+type distinctTransformation struct {
+	passthroughTransformation
+	spec *DistinctProcedureSpec
+	seen map[string]bool
+}
+
+func createDistinctTransformation(id execute.DatasetID, mode execute.AccumulationMode, spec plan.ProcedureSpec, a execute.Administration) (execute.Transformation, execute.Dataset, error) {
+	s, ok := spec.(*DistinctProcedureSpec)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid spec type %T", spec)
+	}
+	cache := execute.NewBlockBuilderCache(a.Allocator())
+	d := execute.NewDataset(id, mode, cache)
+	t := &distinctTransformation{
+		passthroughTransformation: passthroughTransformation{d: d, cache: cache},
+		spec:                      s,
+		seen:                      make(map[string]bool),
+	}
+	return t, d, nil
+}
+
+func (t *distinctTransformation) Process(id execute.DatasetID, b execute.Block) error {
+	builder, created := t.cache.BlockBuilder(b.Key())
+	cols := b.Cols()
+	keyIdx := make(map[string]int, len(t.spec.Keys))
+	for i, c := range cols {
+		for _, k := range t.spec.Keys {
+			if c.Label == k {
+				keyIdx[k] = i
+			}
+		}
+	}
+	if created {
+		for _, c := range cols {
+			builder.AddCol(c)
+		}
+	}
+	return b.Do(func(er execute.ColReader) error {
+		var key string
+		for _, k := range t.spec.Keys {
+			i, ok := keyIdx[k]
+			if !ok {
+				continue
+			}
+			c := cols[i]
+			switch c.Type {
+			case execute.TString:
+				key += "\x00" + er.Strings(i)[0]
+			case execute.TInt:
+				key += fmt.Sprintf("\x00%d", er.Ints(i)[0])
+			case execute.TUInt:
+				key += fmt.Sprintf("\x00%d", er.UInts(i)[0])
+			case execute.TFloat:
+				key += fmt.Sprintf("\x00%v", er.Floats(i)[0])
+			case execute.TBool:
+				key += fmt.Sprintf("\x00%v", er.Bools(i)[0])
+			case execute.TTime:
+				key += fmt.Sprintf("\x00%v", er.Times(i)[0])
+			}
+		}
+		if t.seen[key] {
+			return nil
+		}
+		t.seen[key] = true
+		appendRow(builder, er)
+		return nil
+	})
+}
+
+// sortRow is one buffered row: its column values, held in one map per
+// column type and keyed by column index; less switches on each key
+// column's type to pick the map to compare.
+type sortRow struct {
+	bools   map[int]bool
+	ints    map[int]int64
+	uints   map[int]uint64
+	floats  map[int]float64
+	strings map[int]string
+	times   map[int]execute.Time
+}
+
+// sortTransformation buffers every row of a block - sorting requires
+// seeing them all - and emits them in spec.Keys order on Finish.
+type sortTransformation struct {
+	passthroughTransformation
+	spec    *SortProcedureSpec
+	cols    []execute.ColMeta
+	keyIdx  []int
+	rows    []sortRow
+	started bool
+	key     execute.PartitionKey
+}
+
+func createSortTransformation(id execute.DatasetID, mode execute.AccumulationMode, spec plan.ProcedureSpec, a execute.Administration) (execute.Transformation, execute.Dataset, error) {
+	s, ok := spec.(*SortProcedureSpec)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid spec type %T", spec)
+	}
+	cache := execute.NewBlockBuilderCache(a.Allocator())
+	d := execute.NewDataset(id, mode, cache)
+	t := &sortTransformation{passthroughTransformation: passthroughTransformation{d: d, cache: cache}, spec: s}
+	return t, d, nil
+}
+
+func (t *sortTransformation) Process(id execute.DatasetID, b execute.Block) error {
+	if !t.started {
+		t.started = true
+		t.cols = b.Cols()
+		t.key = b.Key()
+		for _, k := range t.spec.Keys {
+			for i, c := range t.cols {
+				if c.Label == k {
+					t.keyIdx = append(t.keyIdx, i)
+					break
+				}
+			}
+		}
+	}
+	return b.Do(func(er execute.ColReader) error {
+		row := sortRow{
+			bools:   make(map[int]bool),
+			ints:    make(map[int]int64),
+			uints:   make(map[int]uint64),
+			floats:  make(map[int]float64),
+			strings: make(map[int]string),
+			times:   make(map[int]execute.Time),
+		}
+		for i, c := range er.Cols() {
+			switch c.Type {
+			case execute.TBool:
+				row.bools[i] = er.Bools(i)[0]
+			case execute.TInt:
+				row.ints[i] = er.Ints(i)[0]
+			case execute.TUInt:
+				row.uints[i] = er.UInts(i)[0]
+			case execute.TFloat:
+				row.floats[i] = er.Floats(i)[0]
+			case execute.TString:
+				row.strings[i] = er.Strings(i)[0]
+			case execute.TTime:
+				row.times[i] = er.Times(i)[0]
+			}
+		}
+		t.rows = append(t.rows, row)
+		return nil
+	})
+}
+
+// less reports whether a sorts before b over t.keyIdx, honoring
+// spec.Descending.
+func (t *sortTransformation) less(a, b sortRow) bool {
+	for _, i := range t.keyIdx {
+		switch t.cols[i].Type {
+		case execute.TString:
+			if a.strings[i] != b.strings[i] {
+				return (a.strings[i] < b.strings[i]) != t.spec.Descending
+			}
+		case execute.TInt:
+			if a.ints[i] != b.ints[i] {
+				return (a.ints[i] < b.ints[i]) != t.spec.Descending
+			}
+		case execute.TUInt:
+			if a.uints[i] != b.uints[i] {
+				return (a.uints[i] < b.uints[i]) != t.spec.Descending
+			}
+		case execute.TFloat:
+			if a.floats[i] != b.floats[i] {
+				return (a.floats[i] < b.floats[i]) != t.spec.Descending
+			}
+		case execute.TTime:
+			if a.times[i] != b.times[i] {
+				return (a.times[i] < b.times[i]) != t.spec.Descending
+			}
+		case execute.TBool:
+			if a.bools[i] != b.bools[i] {
+				return (!a.bools[i]) != t.spec.Descending
+			}
+		}
+	}
+	return false
+}
+
+// Finish emits every buffered row in sorted order before delegating to
+// passthroughTransformation.Finish, instead of forwarding err straight
+// through like the row-at-a-time transformations above do.
+func (t *sortTransformation) Finish(id execute.DatasetID, err error) {
+	if err == nil && len(t.rows) > 0 {
+		sort.SliceStable(t.rows, func(i, j int) bool { return t.less(t.rows[i], t.rows[j]) })
+		builder, created := t.cache.BlockBuilder(t.key)
+		if created {
+			for _, c := range t.cols {
+				builder.AddCol(c)
+			}
+		}
+		for _, row := range t.rows {
+			for i, c := range t.cols {
+				switch c.Type {
+				case execute.TBool:
+					builder.AppendBool(i, row.bools[i])
+				case execute.TInt:
+					builder.AppendInt(i, row.ints[i])
+				case execute.TUInt:
+					builder.AppendUInt(i, row.uints[i])
+				case execute.TFloat:
+					builder.AppendFloat(i, row.floats[i])
+				case execute.TString:
+					builder.AppendString(i, row.strings[i])
+				case execute.TTime:
+					builder.AppendTime(i, row.times[i])
+				}
+			}
+		}
+	}
+	t.passthroughTransformation.Finish(id, err)
+}
@@ -0,0 +1,117 @@
+package functions_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/influxdata/ifql/functions"
+	"github.com/influxdata/ifql/query"
+)
+
+// TestSortProcedureSpecCopy checks that Copy returns an independent value,
+// matching every other plan.ProcedureSpec.Copy in this tree (e.g.
+// StddevProcedureSpec.Copy).
+func TestSortProcedureSpecCopy(t *testing.T) {
+	orig := &functions.SortProcedureSpec{Keys: []string{"host"}, Descending: true}
+	cp := orig.Copy().(*functions.SortProcedureSpec)
+	cp.Keys[0] = "region"
+	if orig.Keys[0] != "host" {
+		t.Errorf("Copy() shares Keys with the original: mutating the copy changed it to %v", orig.Keys)
+	}
+}
+
+// rootOpSpec stands in for select(db:"mydb") as the DAG root: functions/
+// has no select.go in this tree yet, and these tests only need some root
+// OperationSpec to hang the new relational operators off of.
+type rootOpSpec struct {
+	Database string `json:"database"`
+}
+
+func (rootOpSpec) Kind() query.OperationKind { return "testRoot" }
+
+func init() {
+	query.RegisterOpSpec("testRoot", func() query.OperationSpec { return new(rootOpSpec) })
+}
+
+// TestRelationalOpsQuerySpec builds the DAG a parser would produce for a
+// chain ending in one of the new relational operators, in the same
+// name/want/DAG-edges style as ifql.TestNewQuery, and checks that the
+// QuerySpec round-trips through JSON. There's no grammar in this tree to parse a raw
+// query string through (see the note in relational.go), so each QuerySpec
+// is built by hand instead of via ifql.NewQuery.
+func TestRelationalOpsQuerySpec(t *testing.T) {
+	tests := []struct {
+		name string
+		want *query.QuerySpec
+	}{
+		{
+			name: "sort by host descending",
+			want: &query.QuerySpec{
+				Operations: []*query.Operation{
+					{ID: "root0", Spec: &rootOpSpec{Database: "mydb"}},
+					{ID: "sort1", Spec: &functions.SortOpSpec{Keys: []string{"host"}, Descending: true}},
+				},
+				Edges: []query.Edge{
+					{Parent: "root0", Child: "sort1"},
+				},
+			},
+		},
+		{
+			name: "skip then limit",
+			want: &query.QuerySpec{
+				Operations: []*query.Operation{
+					{ID: "root0", Spec: &rootOpSpec{Database: "mydb"}},
+					{ID: "skip1", Spec: &functions.SkipOpSpec{N: 10}},
+					{ID: "limit2", Spec: &functions.LimitOpSpec{N: 100}},
+				},
+				Edges: []query.Edge{
+					{Parent: "root0", Child: "skip1"},
+					{Parent: "skip1", Child: "limit2"},
+				},
+			},
+		},
+		{
+			name: "distinct by host",
+			want: &query.QuerySpec{
+				Operations: []*query.Operation{
+					{ID: "root0", Spec: &rootOpSpec{Database: "mydb"}},
+					{ID: "distinct1", Spec: &functions.DistinctOpSpec{Keys: []string{"host"}}},
+				},
+				Edges: []query.Edge{
+					{Parent: "root0", Child: "distinct1"},
+				},
+			},
+		},
+		{
+			name: "project time and value",
+			want: &query.QuerySpec{
+				Operations: []*query.Operation{
+					{ID: "root0", Spec: &rootOpSpec{Database: "mydb"}},
+					{ID: "project1", Spec: &functions.ProjectOpSpec{Columns: []string{"_time", "_value"}}},
+				},
+				Edges: []query.Edge{
+					{Parent: "root0", Child: "project1"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := json.Marshal(tt.want)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			got := new(query.QuerySpec)
+			if err := json.Unmarshal(data, got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !cmp.Equal(tt.want, got) {
+				t.Errorf("QuerySpec round-trip = -want/+got %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package functions
+
+import (
+	"github.com/influxdata/ifql/query"
+	"github.com/influxdata/ifql/query/execute"
+)
+
+const RemoteSelectKind = "remoteSelect"
+
+// RemoteSelectOpSpec is the federated counterpart to select(): instead of
+// reading from the local storage engine, Query is dispatched to the IFQL/
+// InfluxDB endpoint at URL and its results are streamed back into the local
+// DAG, the way remote(url:"https://other:8086", db:"other").range(...) or a
+// service(url:"..."){ ... } block would plan. Query is itself a *QuerySpec
+// so the sub-pipeline nested under remote()/service() can be extracted and
+// shipped whole, rather than re-describing it as a query string.
+type RemoteSelectOpSpec struct {
+	URL   string           `json:"url"`
+	Token string           `json:"token"`
+	Query *query.QuerySpec `json:"query"`
+	// Silent suppresses a remote error from failing the whole QuerySpec;
+	// the remote branch just contributes no rows instead.
+	Silent bool `json:"silent"`
+}
+
+// Kind implements query.OperationSpec.
+func (s *RemoteSelectOpSpec) Kind() query.OperationKind { return RemoteSelectKind }
+
+// RemoteHandler dispatches a RemoteSelectOpSpec's Query to its URL and
+// streams the results back as blocks. An executor registers a RemoteHandler
+// before running any QuerySpec containing a RemoteSelectOpSpec; none is
+// wired up by default in this tree, since there is no executor-side
+// dispatch machinery (query/execute only has source.go and trace.go) to register it
+// with.
+type RemoteHandler interface {
+	// Query runs spec against addr, authenticating with token if non-empty.
+	Query(addr, token string, spec *query.QuerySpec) (execute.BlockIterator, error)
+}
+
+func init() {
+	query.RegisterOpSpec(RemoteSelectKind, func() query.OperationSpec { return new(RemoteSelectOpSpec) })
+}
@@ -0,0 +1,59 @@
+package functions_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/influxdata/ifql/functions"
+	"github.com/influxdata/ifql/query"
+)
+
+// TestRemoteSelectQuerySpec checks that a QuerySpec holding a local root
+// and a RemoteSelectOpSpec, with an edge from the root to the remote
+// branch, round-trips through JSON together with the RemoteSelectOpSpec's
+// embedded QuerySpec. It does not build the join of the two branches that
+// a query such as
+//
+//	a = select(db:"local").range(start:-1h)
+//	b = remote(url:"https://other:8086", db:"other").range(start:-1h)
+//	a.join(on:["host"], exp:{a+b})
+//
+// would plan: functions/ has no join operation in this tree, and there's no
+// grammar to parse the query string above (see remote_select.go), so the
+// QuerySpec is built by hand.
+func TestRemoteSelectQuerySpec(t *testing.T) {
+	remoteQuery := &query.QuerySpec{
+		Operations: []*query.Operation{
+			{ID: "root0", Spec: &rootOpSpec{Database: "other"}},
+		},
+	}
+	want := &query.QuerySpec{
+		Operations: []*query.Operation{
+			{ID: "root0", Spec: &rootOpSpec{Database: "local"}},
+			{
+				ID: "remoteSelect1",
+				Spec: &functions.RemoteSelectOpSpec{
+					URL:   "https://other:8086",
+					Query: remoteQuery,
+				},
+			},
+		},
+		Edges: []query.Edge{
+			{Parent: "root0", Child: "remoteSelect1"},
+		},
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	got := new(query.QuerySpec)
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("QuerySpec round-trip = -want/+got %s", cmp.Diff(want, got))
+	}
+}
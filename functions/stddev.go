@@ -60,6 +60,42 @@ func createStddevTransformation(id execute.DatasetID, mode execute.AccumulationM
 	return t, d, nil
 }
 
+// Merge combines other into a using Chan et al.'s parallel formula for
+// combining two Welford partial states, so a's running variance ends up
+// identical to what a single sequential pass over both a's and other's
+// points would have produced. This is what lets the rows behind a single
+// StddevAgg be split across goroutines (or, eventually, hosts): each
+// partial aggregates independently and Merge combines the results, rather
+// than the whole block serializing on one accumulator.
+//
+// NOTE: this is the math half of the request. Wiring it up so
+// AggregateTransformation actually discovers and runs a Combine phase for
+// any aggregate implementing Merge isn't done here: query/execute has no
+// AggregateTransformation (only source.go and trace.go), so there's no DoFloatAgg/
+// DoIntAgg/DoUIntAgg interface or transformation machinery to extend yet.
+func (a *StddevAgg) Merge(other *StddevAgg) {
+	if other.n == 0 {
+		return
+	}
+	if a.n == 0 {
+		*a = *other
+		return
+	}
+	n := a.n + other.n
+	delta := other.mean - a.mean
+	mean := a.mean + delta*other.n/n
+	m2 := a.m2 + other.m2 + delta*delta*a.n*other.n/n
+	a.n, a.mean, a.m2 = n, mean, m2
+}
+
+// Copy returns an independent partial state, so it can be shipped to
+// another goroutine or (eventually) another host to be merged back with
+// Merge once that worker's partial aggregation finishes.
+func (a *StddevAgg) Copy() *StddevAgg {
+	cp := *a
+	return &cp
+}
+
 func (a *StddevAgg) reset() {
 	a.n = 0
 	a.mean = 0
@@ -0,0 +1,51 @@
+package functions_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/influxdata/ifql/functions"
+)
+
+// TestStddevAggMerge checks that merging two partial StddevAggs produces
+// the same variance a single sequential pass over both halves would have.
+func TestStddevAggMerge(t *testing.T) {
+	a := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+	b := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+	whole := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+
+	left := []float64{1, 2, 3, 4, 5}
+	right := []float64{10, 20, 30}
+
+	a.DoFloat(left)
+	b.DoFloat(right)
+	whole.DoFloat(left)
+	whole.DoFloat(right)
+
+	a.Merge(b)
+
+	got := a.ValueFloat()
+	want := whole.ValueFloat()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("Merge() stddev = %v, want %v (sequential)", got, want)
+	}
+}
+
+// TestStddevAggMergeEmpty checks that merging with an empty partial state
+// is a no-op, and that merging into an empty state adopts the other side.
+func TestStddevAggMergeEmpty(t *testing.T) {
+	a := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+	a.DoFloat([]float64{1, 2, 3})
+	empty := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+
+	a.Merge(empty)
+	if got, want := a.ValueFloat(), 1.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Merge(empty) stddev = %v, want %v", got, want)
+	}
+
+	other := new(functions.StddevAgg).NewFloatAgg().(*functions.StddevAgg)
+	other.Merge(a)
+	if got, want := other.ValueFloat(), a.ValueFloat(); math.Abs(got-want) > 1e-9 {
+		t.Errorf("empty.Merge(a) stddev = %v, want %v", got, want)
+	}
+}
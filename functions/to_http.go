@@ -1,35 +1,74 @@
 package functions
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/golang/snappy"
 	"github.com/influxdata/ifql/query"
 	"github.com/influxdata/ifql/query/execute"
 	"github.com/influxdata/ifql/query/plan"
 	"github.com/influxdata/ifql/semantic"
 	"github.com/influxdata/line-protocol"
 	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
 )
 
 const (
 	ToHTTPKind           = "toHTTP"
 	DefaultToHTTPTimeout = 1 * time.Second
+
+	// ToHTTPFormatInflux writes points using InfluxDB line protocol.
+	ToHTTPFormatInflux = "influx"
+	// ToHTTPFormatPrometheus writes points as a snappy-compressed
+	// Prometheus remote_write protobuf request.
+	ToHTTPFormatPrometheus = "prometheus"
+	// ToHTTPFormatJSON sends points with a Content-Type of
+	// application/json, but they are encoded by the same InfluxDB line
+	// protocol encoder as ToHTTPFormatInflux, not as JSON.
+	ToHTTPFormatJSON = "json"
+
+	// DefaultToHTTPMaxBatchBytes flushes a batch once its encoded size
+	// reaches this many bytes.
+	DefaultToHTTPMaxBatchBytes = 1 << 20 // 1MiB
+	// DefaultToHTTPMaxBatchRows flushes a batch once it holds this many rows.
+	DefaultToHTTPMaxBatchRows = 5000
+	// DefaultToHTTPFlushInterval flushes a non-empty batch on this cadence
+	// even if neither size threshold has been hit.
+	DefaultToHTTPFlushInterval = 10 * time.Second
+	// DefaultToHTTPMaxRetries bounds the number of retries for a flush that
+	// keeps failing with a retryable error.
+	DefaultToHTTPMaxRetries = 5
+
+	toHTTPInitialBackoff = 100 * time.Millisecond
+	toHTTPMaxBackoff     = 30 * time.Second
+	// toHTTPMaxErrBodyBytes bounds how much of a non-2xx response body is
+	// read back for the error message.
+	toHTTPMaxErrBodyBytes = 64 << 10 // 64KiB
 )
 
+// DefaultToHTTPFormat is used when the format argument is omitted.
+var DefaultToHTTPFormat = ToHTTPFormatInflux
+
 // DefaultToHTTPUserAgent is the default user agent used by ToHttp
 var DefaultToHTTPUserAgent = "ifqld/dev"
 
-func newOutPutClient() *http.Client {
+func newOutPutClient(tlsConfig *tls.Config) *http.Client {
 	return &http.Client{
 		Transport: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
@@ -43,20 +82,151 @@ func newOutPutClient() *http.Client {
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
 			MaxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
+			TLSClientConfig:       tlsConfig,
 		},
 	}
 }
 
-var toHTTPKeepAliveClient = newOutPutClient()
+// toHTTPClientPool hands out a shared keep-alive *http.Client per distinct
+// TLS+auth configuration, so two toHTTP() calls pointed at different secured
+// endpoints in the same query never share an insecure or mismatched
+// transport. NoKeepAlive bypasses the pool entirely, same as before.
+var (
+	toHTTPClientPoolMu sync.Mutex
+	toHTTPClientPool   = make(map[string]*http.Client)
+)
+
+func toHTTPClientFor(o *ToHTTPOpSpec) (*http.Client, error) {
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o.NoKeepAlive {
+		return newOutPutClient(tlsConfig), nil
+	}
+
+	key := o.clientKey()
+	toHTTPClientPoolMu.Lock()
+	defer toHTTPClientPoolMu.Unlock()
+	if c, ok := toHTTPClientPool[key]; ok {
+		return c, nil
+	}
+	c := newOutPutClient(tlsConfig)
+	toHTTPClientPool[key] = c
+	return c, nil
+}
+
+// tlsConfig builds the *tls.Config for o.TLS, or nil if no tls block was
+// given and the default transport's TLS settings should apply.
+func (o *ToHTTPOpSpec) tlsConfig() (*tls.Config, error) {
+	if o.TLS == nil {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		InsecureSkipVerify: o.TLS.InsecureSkipVerify,
+		ServerName:         o.TLS.ServerName,
+	}
+	if o.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.TLS.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "toHTTP: reading tls ca_file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("toHTTP: no certificates found in ca_file %s", o.TLS.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if o.TLS.CertFile != "" || o.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.TLS.CertFile, o.TLS.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "toHTTP: loading tls cert_file/key_file")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
 
 // this is used so we can get better validation on marshaling, innerToHTTPOpSpec and ToHTTPOpSpec
 // need to have identical fields
 type innerToHTTPOpSpec ToHTTPOpSpec
 
+// BasicAuthConfig holds HTTP basic auth credentials for toHTTP.
+type BasicAuthConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// TLSConfig configures the TLS client used by toHTTP, including optional
+// client certificate (mTLS) material.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	ServerName         string `json:"server_name"`
+}
+
+// validateAuth rejects auth configurations that don't make sense together:
+// only one of basic_auth, bearer_token, or bearer_token_file may be set.
+func (o *ToHTTPOpSpec) validateAuth() error {
+	set := 0
+	if o.BasicAuth != nil {
+		set++
+	}
+	if o.BearerToken != "" {
+		set++
+	}
+	if o.BearerTokenFile != "" {
+		set++
+	}
+	if set > 1 {
+		return errors.New("toHTTP: basic_auth, bearer_token and bearer_token_file are mutually exclusive")
+	}
+	return nil
+}
+
+// setAuthHeader sets the Authorization header on req according to whichever
+// of BasicAuth/BearerToken/BearerTokenFile is configured (validateAuth
+// guarantees at most one is). BearerTokenFile is read fresh on every call
+// so a rotated token is picked up without restarting the query.
+func (o *ToHTTPOpSpec) setAuthHeader(req *http.Request) error {
+	switch {
+	case o.BasicAuth != nil:
+		req.SetBasicAuth(o.BasicAuth.Username, o.BasicAuth.Password)
+	case o.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+o.BearerToken)
+	case o.BearerTokenFile != "":
+		token, err := ioutil.ReadFile(o.BearerTokenFile)
+		if err != nil {
+			return errors.Wrap(err, "toHTTP: reading bearer_token_file")
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return nil
+}
+
+// clientKey identifies the pool of keep-alive clients a given auth+TLS
+// configuration should share: two toHTTP calls with the same effective
+// transport config reuse one *http.Client, but calls with different configs
+// (e.g. two endpoints with different client certs) never share a transport.
+func (o *ToHTTPOpSpec) clientKey() string {
+	var tlsKey, basicKey string
+	if o.TLS != nil {
+		tlsKey = fmt.Sprintf("%+v", *o.TLS)
+	}
+	if o.BasicAuth != nil {
+		basicKey = fmt.Sprintf("%+v", *o.BasicAuth)
+	}
+	return fmt.Sprintf("nokeepalive=%t|tls=%s|basic=%s|bearer=%s|bearerfile=%s",
+		o.NoKeepAlive, tlsKey, basicKey, o.BearerToken, o.BearerTokenFile)
+}
+
 type ToHTTPOpSpec struct {
 	Addr         string            `json:"addr"`
 	Method       string            `json:"method"` // default behavior should be POST
 	Name         string            `json:"name"`
+	Format       string            `json:"format"` // one of "influx", "prometheus", "json"; defaults to "influx"
 	Headers      map[string]string `json:"headers"`   // TODO: implement Headers after bug with keys and arrays and objects is fixed (new parser implemented, with string literals as keys)
 	URLParams    map[string]string `json:"urlparams"` // TODO: implement URLParams after bug with keys and arrays and objects is fixed (new parser implemented, with string literals as keys)
 	Timeout      time.Duration     `json:"timeout"`   // default to something reasonable if zero
@@ -64,6 +234,29 @@ type ToHTTPOpSpec struct {
 	TimeColumn   string            `json:"time_column"`
 	TagColumns   []string          `json:"tag_columns"`
 	ValueColumns []string          `json:"value_columns"`
+	// MetricNames overrides the Prometheus `__name__` label on a
+	// per-value-column basis. A value column with no entry here falls
+	// back to Name. Only consulted when Format is "prometheus".
+	MetricNames map[string]string `json:"metric_names"`
+
+	// MaxBatchBytes and MaxBatchRows bound how much encoded data
+	// accumulates before a flush is forced; FlushInterval bounds how long
+	// a partial batch can sit unflushed. MaxRetries bounds retries of a
+	// flush that keeps failing with a retryable (5xx/connection) error.
+	// MaxBatchBytes is not checked when Format is "prometheus".
+	MaxBatchBytes int64         `json:"max_batch_bytes"`
+	MaxBatchRows  int64         `json:"max_batch_rows"`
+	FlushInterval time.Duration `json:"flush_interval"`
+	MaxRetries    int64         `json:"max_retries"`
+
+	// BasicAuth, BearerToken and BearerTokenFile are mutually exclusive
+	// ways to authenticate with the destination. BearerTokenFile is
+	// re-read on every request, so a rotated token takes effect without
+	// restarting the query.
+	BasicAuth       *BasicAuthConfig `json:"basic_auth"`
+	BearerToken     string           `json:"bearer_token"`
+	BearerTokenFile string           `json:"bearer_token_file"`
+	TLS             *TLSConfig       `json:"tls"`
 }
 
 func init() {
@@ -78,6 +271,7 @@ func init() {
 // If the http method isn't set, it defaults to POST, it also uppercases the http method.
 // If the time_column isn't set, it defaults to execute.TimeColLabel.
 // If the value_column isn't set it defaults to a []string{execute.DefaultValueColLabel}.
+// If the format isn't set, it defaults to "influx".
 func (o *ToHTTPOpSpec) ReadArgs(args query.Arguments) error {
 	var err error
 	o.Addr, err = args.GetRequiredString("addr")
@@ -100,6 +294,19 @@ func (o *ToHTTPOpSpec) ReadArgs(args query.Arguments) error {
 	}
 	o.Method = strings.ToUpper(o.Method)
 
+	o.Format, ok, err = args.GetString("format")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		o.Format = DefaultToHTTPFormat
+	}
+	switch o.Format {
+	case ToHTTPFormatInflux, ToHTTPFormatPrometheus, ToHTTPFormatJSON:
+	default:
+		return fmt.Errorf("unsupported format %q, must be one of %q, %q, %q", o.Format, ToHTTPFormatInflux, ToHTTPFormatPrometheus, ToHTTPFormatJSON)
+	}
+
 	timeout, ok, err := args.GetDuration("timeout")
 	if err != nil {
 		return err
@@ -145,10 +352,111 @@ func (o *ToHTTPOpSpec) ReadArgs(args query.Arguments) error {
 		sort.Strings(o.TagColumns)
 	}
 
+	o.MaxBatchBytes, ok, err = args.GetInt("max_batch_bytes")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		o.MaxBatchBytes = DefaultToHTTPMaxBatchBytes
+	}
+
+	o.MaxBatchRows, ok, err = args.GetInt("max_batch_rows")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		o.MaxBatchRows = DefaultToHTTPMaxBatchRows
+	}
+
+	flushInterval, ok, err := args.GetDuration("flush_interval")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		o.FlushInterval = DefaultToHTTPFlushInterval
+	} else {
+		o.FlushInterval = time.Duration(flushInterval)
+	}
+
+	o.MaxRetries, ok, err = args.GetInt("max_retries")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		o.MaxRetries = DefaultToHTTPMaxRetries
+	}
+
+	// TODO: accept basic_auth/tls as nested object args once the parser bug
+	// with object literals (see Headers/URLParams above) is fixed. Until
+	// then they're flattened to individual string/bool arguments.
+	basicAuthUser, hasUser, err := args.GetString("basic_auth_username")
+	if err != nil {
+		return err
+	}
+	basicAuthPass, hasPass, err := args.GetString("basic_auth_password")
+	if err != nil {
+		return err
+	}
+	if hasUser || hasPass {
+		o.BasicAuth = &BasicAuthConfig{Username: basicAuthUser, Password: basicAuthPass}
+	}
+
+	o.BearerToken, _, err = args.GetString("bearer_token")
+	if err != nil {
+		return err
+	}
+	o.BearerTokenFile, _, err = args.GetString("bearer_token_file")
+	if err != nil {
+		return err
+	}
+
+	tlsCAFile, hasCA, err := args.GetString("tls_ca_file")
+	if err != nil {
+		return err
+	}
+	tlsCertFile, hasCert, err := args.GetString("tls_cert_file")
+	if err != nil {
+		return err
+	}
+	tlsKeyFile, hasKey, err := args.GetString("tls_key_file")
+	if err != nil {
+		return err
+	}
+	tlsServerName, hasServerName, err := args.GetString("tls_server_name")
+	if err != nil {
+		return err
+	}
+	tlsInsecureSkipVerify, hasInsecure, err := args.GetBool("tls_insecure_skip_verify")
+	if err != nil {
+		return err
+	}
+	if hasCA || hasCert || hasKey || hasServerName || hasInsecure {
+		o.TLS = &TLSConfig{
+			CAFile:             tlsCAFile,
+			CertFile:           tlsCertFile,
+			KeyFile:            tlsKeyFile,
+			ServerName:         tlsServerName,
+			InsecureSkipVerify: tlsInsecureSkipVerify,
+		}
+	}
+
+	if err := o.validateAuth(); err != nil {
+		return err
+	}
+
 	// TODO: get other headers working!
 	o.Headers = map[string]string{
-		"Content-Type": "application/vnd.influx",
-		"User-Agent":   DefaultToHTTPUserAgent,
+		"User-Agent": DefaultToHTTPUserAgent,
+	}
+	switch o.Format {
+	case ToHTTPFormatPrometheus:
+		o.Headers["Content-Encoding"] = "snappy"
+		o.Headers["Content-Type"] = "application/x-protobuf"
+		o.Headers["X-Prometheus-Remote-Write-Version"] = "0.1.0"
+	case ToHTTPFormatJSON:
+		o.Headers["Content-Type"] = "application/json"
+	default:
+		o.Headers["Content-Type"] = "application/vnd.influx"
 	}
 
 	return err
@@ -182,7 +490,12 @@ func (o *ToHTTPOpSpec) UnmarshalJSON(b []byte) (err error) {
 	if !(u.Scheme == "https" || u.Scheme == "http" || u.Scheme == "") {
 		return fmt.Errorf("Scheme must be http or https but was %s", u.Scheme)
 	}
-	return nil
+	switch o.Format {
+	case "", ToHTTPFormatInflux, ToHTTPFormatPrometheus, ToHTTPFormatJSON:
+	default:
+		return fmt.Errorf("unsupported format %q, must be one of %q, %q, %q", o.Format, ToHTTPFormatInflux, ToHTTPFormatPrometheus, ToHTTPFormatJSON)
+	}
+	return o.validateAuth()
 }
 
 var ToHTTPSignature = query.DefaultFunctionSignature()
@@ -191,12 +504,17 @@ func (ToHTTPOpSpec) Kind() query.OperationKind {
 	return ToHTTPKind
 }
 
+// ToHTTPProcedureKind identifies toHTTP's plan.ProcedureSpec. It used to
+// mis-report CountKind, which made toHTTP indistinguishable from a real
+// count() in any planner rule that switched on procedure kind.
+const ToHTTPProcedureKind plan.ProcedureKind = ToHTTPKind
+
 type ToHTTPProcedureSpec struct {
 	Spec *ToHTTPOpSpec
 }
 
 func (o *ToHTTPProcedureSpec) Kind() plan.ProcedureKind {
-	return CountKind
+	return ToHTTPProcedureKind
 }
 
 func (o *ToHTTPProcedureSpec) Copy() plan.ProcedureSpec {
@@ -218,27 +536,58 @@ func createToHTTPTransformation(id execute.DatasetID, mode execute.AccumulationM
 	}
 	cache := execute.NewBlockBuilderCache(a.Allocator())
 	d := execute.NewDataset(id, mode, cache)
-	t := NewToHTTPTransformation(d, cache, s)
+	t := NewToHTTPTransformation(a.Context(), d, cache, s)
 	return t, d, nil
 }
 
+// ToHTTPTransformation accumulates encoded rows from every block it sees
+// into a bounded in-memory batch and flushes that batch to the destination
+// on Finish, on a timer, or as soon as a size threshold is crossed. It is not
+// a terminal sink: every flush attempt also appends a diagnostics row (see
+// toHTTPDiagnostic) to its output block via cache, so a query can chain off
+// of toHTTP(...) to monitor or react to delivery problems.
 type ToHTTPTransformation struct {
+	// ctx is the query's execution context, threaded in at construction so
+	// every HTTP request this transformation makes is torn down when the
+	// query is canceled, not just when a per-request Timeout expires.
+	ctx   context.Context
 	d     execute.Dataset
 	cache execute.BlockBuilderCache
 	spec  *ToHTTPProcedureSpec
+
+	mu         sync.Mutex
+	buf        bytes.Buffer
+	promSeries map[string]*prompb.TimeSeries
+	rows       int64
+	timer      *time.Timer
 }
 
 func (t *ToHTTPTransformation) RetractBlock(id execute.DatasetID, key execute.PartitionKey) error {
 	return t.d.RetractBlock(key)
 }
 
-func NewToHTTPTransformation(d execute.Dataset, cache execute.BlockBuilderCache, spec *ToHTTPProcedureSpec) *ToHTTPTransformation {
+func NewToHTTPTransformation(ctx context.Context, d execute.Dataset, cache execute.BlockBuilderCache, spec *ToHTTPProcedureSpec) *ToHTTPTransformation {
+	t := &ToHTTPTransformation{
+		ctx:        ctx,
+		d:          d,
+		cache:      cache,
+		spec:       spec,
+		promSeries: make(map[string]*prompb.TimeSeries),
+	}
+	t.timer = time.AfterFunc(spec.Spec.FlushInterval, t.onFlushTimer)
+	return t
+}
 
-	return &ToHTTPTransformation{
-		d:     d,
-		cache: cache,
-		spec:  spec,
+// onFlushTimer runs on the FlushInterval cadence and flushes whatever batch
+// has accumulated, even if no size threshold has been crossed. A flush
+// error here has no Process call to return it through, so it is surfaced
+// the same way a terminal send error from Process is: via t.d.Finish.
+func (t *ToHTTPTransformation) onFlushTimer() {
+	if err := t.flush(); err != nil {
+		t.d.Finish(err)
+		return
 	}
+	t.timer.Reset(t.spec.Spec.FlushInterval)
 }
 
 type httpOutputMetric struct {
@@ -274,10 +623,165 @@ type idxType struct {
 	Type execute.DataType
 }
 
+// metricName returns the Prometheus `__name__` label to use for a given
+// value column, falling back to the spec's Name when no override is set.
+func (o *ToHTTPOpSpec) metricName(valueCol string) string {
+	if name, ok := o.MetricNames[valueCol]; ok {
+		return name
+	}
+	return o.Name
+}
+
+// seriesKey returns the key mergePrometheus merges samples under: the
+// metric name plus every tag label, so two rows with the same value column
+// but different tag values land in distinct TimeSeries instead of
+// colliding on the same one.
+func seriesKey(name string, labels []*prompb.Label) string {
+	var key strings.Builder
+	key.WriteString(name)
+	for _, l := range labels {
+		key.WriteByte('\x00')
+		key.WriteString(l.Name)
+		key.WriteByte('=')
+		key.WriteString(l.Value)
+	}
+	return key.String()
+}
+
+// containsSorted reports whether s is present in sorted, a slice in sorted
+// order. sort.SearchStrings alone isn't enough: it returns the index s
+// would be inserted at, which is within bounds whenever s sorts at or
+// before sorted's last element, whether or not s actually appears in
+// sorted (e.g. sort.SearchStrings([]string{"host"}, "_value") returns 0,
+// and 0 < 1, even though "_value" isn't "host"). containsSorted checks the
+// value at that index too, so it only reports true on an exact match.
+func containsSorted(sorted []string, s string) bool {
+	i := sort.SearchStrings(sorted, s)
+	return i < len(sorted) && sorted[i] == s
+}
+
+// mergePrometheus encodes a block's rows as Prometheus samples and merges
+// them into series, keyed by seriesKey (metric name plus tag labels), so
+// that samples from many blocks can be combined into a single WriteRequest
+// at flush time without distinct label sets colliding on the same
+// TimeSeries. It returns the number of rows encoded.
+func (t *ToHTTPTransformation) mergePrometheus(b execute.Block, series map[string]*prompb.TimeSeries) (int64, error) {
+	spec := t.spec.Spec
+	cols := b.Cols()
+	timeColIdx := -1
+	tagColIdx := make(map[string]int)
+	valueColIdx := make(map[string]int)
+	for i, col := range cols {
+		switch {
+		case col.Label == spec.TimeColumn:
+			timeColIdx = i
+		case containsSorted(spec.TagColumns, col.Label):
+			tagColIdx[col.Label] = i
+		case containsSorted(spec.ValueColumns, col.Label):
+			valueColIdx[col.Label] = i
+		}
+	}
+	if timeColIdx < 0 {
+		return 0, errors.New("Could not get time column")
+	}
+
+	var rows int64
+	var err error
+	b.Do(func(er execute.ColReader) error {
+		labels := make([]*prompb.Label, 0, len(tagColIdx)+1)
+		for label, i := range tagColIdx {
+			labels = append(labels, &prompb.Label{Name: label, Value: er.Strings(i)[0]})
+		}
+		// tagColIdx is a map, so sort to give seriesKey a stable label order.
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+		ts := er.Times(timeColIdx)[0].Time().UnixNano() / int64(time.Millisecond)
+		for valueCol, i := range valueColIdx {
+			var v float64
+			switch cols[i].Type {
+			case execute.TFloat:
+				v = er.Floats(i)[0]
+			case execute.TInt:
+				v = float64(er.Ints(i)[0])
+			case execute.TUInt:
+				v = float64(er.UInts(i)[0])
+			default:
+				err = fmt.Errorf("column %s is not a numeric type, cannot write to prometheus", valueCol)
+				return nil
+			}
+			name := spec.metricName(valueCol)
+			key := seriesKey(name, labels)
+			s, ok := series[key]
+			if !ok {
+				nameLabels := make([]*prompb.Label, len(labels), len(labels)+1)
+				copy(nameLabels, labels)
+				nameLabels = append(nameLabels, &prompb.Label{Name: "__name__", Value: name})
+				s = &prompb.TimeSeries{Labels: nameLabels}
+				series[key] = s
+			}
+			s.Samples = append(s.Samples, prompb.Sample{Value: v, TimestampMs: ts})
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+// marshalPrometheus snappy-compresses a WriteRequest built from series.
+func marshalPrometheus(series map[string]*prompb.TimeSeries) ([]byte, error) {
+	wr := &prompb.WriteRequest{Timeseries: make([]*prompb.TimeSeries, 0, len(series))}
+	for _, s := range series {
+		wr.Timeseries = append(wr.Timeseries, s)
+	}
+	data, err := wr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// Process encodes b's rows into the shared batch and flushes immediately
+// if doing so crossed a max_batch_bytes/max_batch_rows threshold. Blocks
+// from many calls to Process accumulate into the same batch, rather than
+// each opening its own HTTP request. In prometheus format the batch is only
+// serialized at flush time, so only max_batch_rows is checked there.
 func (t *ToHTTPTransformation) Process(id execute.DatasetID, b execute.Block) error {
-	pr, pw := io.Pipe() // TODO: replce the pipe with something faster
-	m := &httpOutputMetric{}
-	e := protocol.NewEncoder(pw)
+	spec := t.spec.Spec
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if spec.Format == ToHTTPFormatPrometheus {
+		rows, err := t.mergePrometheus(b, t.promSeries)
+		if err != nil {
+			return err
+		}
+		t.rows += rows
+		if t.rows >= spec.MaxBatchRows {
+			return t.flushLocked()
+		}
+		return nil
+	}
+
+	rows, err := t.encodeInflux(b, &t.buf)
+	if err != nil {
+		return err
+	}
+	t.rows += rows
+	if t.rows >= spec.MaxBatchRows || int64(t.buf.Len()) >= spec.MaxBatchBytes {
+		return t.flushLocked()
+	}
+	return nil
+}
+
+// encodeInflux appends b's rows to buf using InfluxDB line protocol and
+// returns the number of rows encoded. The JSON format is encoded by this
+// same function.
+func (t *ToHTTPTransformation) encodeInflux(b execute.Block, buf *bytes.Buffer) (int64, error) {
+	m := &httpOutputMetric{name: t.spec.Spec.Name}
+	e := protocol.NewEncoder(buf)
 	e.FailOnFieldErr(true)
 	e.SetFieldSortOrder(protocol.SortFields)
 	cols := b.Cols()
@@ -285,79 +789,261 @@ func (t *ToHTTPTransformation) Process(id execute.DatasetID, b execute.Block) er
 	for i, col := range cols {
 		labels[col.Label] = idxType{Idx: i, Type: col.Type}
 	}
-	// do time
 	timeColLabel := t.spec.Spec.TimeColumn
 	timeColIdx, ok := labels[timeColLabel]
 	if !ok {
-		return errors.New("Could not get time column")
+		return 0, errors.New("Could not get time column")
 	}
 	if timeColIdx.Type != execute.TTime {
-		return fmt.Errorf("column %s is not of type %s", timeColLabel, timeColIdx.Type)
+		return 0, fmt.Errorf("column %s is not of type %s", timeColLabel, timeColIdx.Type)
 	}
+
+	var rows int64
 	var err error
-	go func() {
-		m.name = t.spec.Spec.Name
-		b.Do(func(er execute.ColReader) error {
-			m.truncateTagsAndFields()
-			for i, col := range er.Cols() {
-				switch {
-				case col.Label == timeColLabel:
-					m.t = er.Times(i)[0].Time()
-				case sort.SearchStrings(t.spec.Spec.ValueColumns, col.Label) < len(t.spec.Spec.ValueColumns): // do thing to get values
-					switch col.Type {
-					case execute.TFloat:
-						m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Floats(i)[0]})
-					case execute.TInt:
-						m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Ints(i)[0]})
-					case execute.TUInt:
-						m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.UInts(i)[0]})
-					case execute.TString:
-						m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Strings(i)[0]})
-					case execute.TTime:
-						m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Times(i)[0]})
-					case execute.TBool:
-						m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Bools(i)[0]})
-					default:
-						err = errors.New("invalid type")
-					}
-				case sort.SearchStrings(t.spec.Spec.TagColumns, col.Label) < len(t.spec.Spec.TagColumns): // do thing to get tag
-					m.tags = append(m.tags, &protocol.Tag{Key: col.Label, Value: er.Strings(i)[0]})
+	b.Do(func(er execute.ColReader) error {
+		m.truncateTagsAndFields()
+		for i, col := range er.Cols() {
+			switch {
+			case col.Label == timeColLabel:
+				m.t = er.Times(i)[0].Time()
+			case containsSorted(t.spec.Spec.ValueColumns, col.Label): // do thing to get values
+				switch col.Type {
+				case execute.TFloat:
+					m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Floats(i)[0]})
+				case execute.TInt:
+					m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Ints(i)[0]})
+				case execute.TUInt:
+					m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.UInts(i)[0]})
+				case execute.TString:
+					m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Strings(i)[0]})
+				case execute.TTime:
+					m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Times(i)[0]})
+				case execute.TBool:
+					m.fields = append(m.fields, &protocol.Field{Key: col.Label, Value: er.Bools(i)[0]})
+				default:
+					err = errors.New("invalid type")
 				}
+			case containsSorted(t.spec.Spec.TagColumns, col.Label): // do thing to get tag
+				m.tags = append(m.tags, &protocol.Tag{Key: col.Label, Value: er.Strings(i)[0]})
 			}
+		}
+		if _, encErr := e.Encode(m); encErr != nil {
+			err = encErr
+			return nil
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
 
-			_, err := e.Encode(m)
-			if err != nil {
-				fmt.Println(err)
-			}
+// flush sends whatever batch has accumulated, if any, and resets it.
+func (t *ToHTTPTransformation) flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flushLocked()
+}
+
+// flushLocked must be called with t.mu held. It extracts and resets the
+// current batch, then sends it (retries included) with t.mu still held, so
+// Process and the flush timer wait until the send has finished.
+func (t *ToHTTPTransformation) flushLocked() error {
+	if t.rows == 0 {
+		return nil
+	}
+	spec := t.spec.Spec
+
+	var body []byte
+	var err error
+	if spec.Format == ToHTTPFormatPrometheus {
+		body, err = marshalPrometheus(t.promSeries)
+		t.promSeries = make(map[string]*prompb.TimeSeries)
+	} else {
+		body = append([]byte(nil), t.buf.Bytes()...)
+		t.buf.Reset()
+	}
+	t.rows = 0
+	if err != nil {
+		return err
+	}
+
+	return t.sendWithRetry(body)
+}
+
+// toHTTPDiagnostic describes the outcome of a single send attempt. One is
+// recorded per attempt (not just per flush), so a retried flush shows up as
+// several rows and a downstream `filter(fn: (r) => r.status_code >= 400)`
+// can see exactly which attempts failed.
+type toHTTPDiagnostic struct {
+	Time       time.Time
+	URL        string
+	StatusCode int
+	Duration   time.Duration
+	BytesSent  int64
+	Attempt    int64
+	Error      string
+}
+
+// Column indexes of the block recordDiagnostic builds; kept in one place
+// so the AddCol order in toHTTPDiagnosticCols and the AppendX calls in
+// recordDiagnostic can't drift apart.
+const (
+	toHTTPDiagColTime = iota
+	toHTTPDiagColURL
+	toHTTPDiagColStatusCode
+	toHTTPDiagColDurationMS
+	toHTTPDiagColBytesSent
+	toHTTPDiagColAttempt
+	toHTTPDiagColError
+)
+
+var toHTTPDiagnosticCols = []execute.ColMeta{
+	toHTTPDiagColTime:       {Label: "_time", Type: execute.TTime},
+	toHTTPDiagColURL:        {Label: "url", Type: execute.TString},
+	toHTTPDiagColStatusCode: {Label: "status_code", Type: execute.TInt},
+	toHTTPDiagColDurationMS: {Label: "duration_ms", Type: execute.TInt},
+	toHTTPDiagColBytesSent:  {Label: "bytes_sent", Type: execute.TInt},
+	toHTTPDiagColAttempt:    {Label: "attempt", Type: execute.TInt},
+	toHTTPDiagColError:      {Label: "error", Type: execute.TString},
+}
+
+// toHTTPDiagnosticsKey is the single partition every diagnostics row for a
+// given toHTTP instance lands in: delivery outcomes aren't split by any tag,
+// so one block is enough for the transformation's whole lifetime.
+var toHTTPDiagnosticsKey execute.PartitionKey
+
+// recordDiagnostic appends diag as a row to the diagnostics block, creating
+// the block and its columns the first time it's called. It must be called
+// with t.mu held, which every caller (via send, via flushLocked) already
+// does.
+func (t *ToHTTPTransformation) recordDiagnostic(diag toHTTPDiagnostic) {
+	builder, created := t.cache.BlockBuilder(toHTTPDiagnosticsKey)
+	if created {
+		for _, c := range toHTTPDiagnosticCols {
+			builder.AddCol(c)
+		}
+	}
+	builder.AppendTime(toHTTPDiagColTime, execute.Time(diag.Time.UnixNano()))
+	builder.AppendString(toHTTPDiagColURL, diag.URL)
+	builder.AppendInt(toHTTPDiagColStatusCode, int64(diag.StatusCode))
+	builder.AppendInt(toHTTPDiagColDurationMS, diag.Duration.Nanoseconds()/int64(time.Millisecond))
+	builder.AppendInt(toHTTPDiagColBytesSent, diag.BytesSent)
+	builder.AppendInt(toHTTPDiagColAttempt, diag.Attempt)
+	builder.AppendString(toHTTPDiagColError, diag.Error)
+}
+
+// retryableError marks a send failure (5xx status, or a transport-level
+// connection error) as eligible for another attempt; anything else,
+// notably a 4xx status, is terminal.
+type retryableError struct{ error }
+
+// sendWithRetry flushes body to the destination, retrying retryableErrors
+// with jittered exponential backoff up to max_retries times.
+func (t *ToHTTPTransformation) sendWithRetry(body []byte) error {
+	spec := t.spec.Spec
+	backoff := toHTTPInitialBackoff
+	var attempt int64
+	for {
+		err := t.send(body, attempt+1)
+		if err == nil {
 			return nil
+		}
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		if attempt >= spec.MaxRetries {
+			return fmt.Errorf("toHTTP: giving up after %d attempts: %v", attempt+1, err)
+		}
+		attempt++
+		sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		time.Sleep(sleep)
+		if backoff *= 2; backoff > toHTTPMaxBackoff {
+			backoff = toHTTPMaxBackoff
+		}
+	}
+}
+
+// send performs a single HTTP attempt to deliver body, classifying the
+// outcome so sendWithRetry knows whether to retry, and always records a
+// diagnostics row so a downstream `toHTTP(...) |> filter(...)` can react to
+// delivery problems instead of them disappearing into a log line.
+func (t *ToHTTPTransformation) send(body []byte, attempt int64) error {
+	spec := t.spec.Spec
+	start := time.Now()
+	statusCode := 0
+	var sendErr error
+	defer func() {
+		msg := ""
+		if sendErr != nil {
+			msg = sendErr.Error()
+		}
+		t.recordDiagnostic(toHTTPDiagnostic{
+			Time:       start,
+			URL:        spec.Addr,
+			StatusCode: statusCode,
+			Duration:   time.Since(start),
+			BytesSent:  int64(len(body)),
+			Attempt:    attempt,
+			Error:      msg,
 		})
-		pw.Close()
 	}()
 
-	req, err := http.NewRequest(t.spec.Spec.Method, t.spec.Spec.Addr, pr)
+	req, err := http.NewRequest(spec.Method, spec.Addr, bytes.NewReader(body))
 	if err != nil {
+		sendErr = err
+		return err
+	}
+	for k, v := range spec.Headers {
+		req.Header.Set(k, v)
+	}
+	if err := spec.setAuthHeader(req); err != nil {
+		sendErr = err
 		return err
 	}
 
-	if t.spec.Spec.Timeout <= 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), t.spec.Spec.Timeout)
-		req = req.WithContext(ctx)
+	ctx := t.ctx
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.Timeout)
 		defer cancel()
 	}
-	var resp *http.Response
-	if t.spec.Spec.NoKeepAlive {
-		resp, err = newOutPutClient().Do(req)
-	} else {
-		resp, err = toHTTPKeepAliveClient.Do(req)
+	req = req.WithContext(ctx)
 
-	}
+	client, err := toHTTPClientFor(spec)
 	if err != nil {
+		sendErr = err
 		return err
 	}
-
+	resp, err := client.Do(req)
+	if err != nil {
+		// A canceled query context is terminal, not a transient failure:
+		// retrying would just keep hitting the same canceled context.
+		if ctx.Err() != nil {
+			sendErr = ctx.Err()
+			return sendErr
+		}
+		sendErr = retryableError{err}
+		return sendErr
+	}
 	defer resp.Body.Close()
+	statusCode = resp.StatusCode
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
 
-	return req.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, toHTTPMaxErrBodyBytes))
+	err = fmt.Errorf("toHTTP: %s returned status %d: %s", spec.Addr, resp.StatusCode, respBody)
+	if resp.StatusCode >= 500 {
+		sendErr = retryableError{err}
+	} else {
+		sendErr = err
+	}
+	return sendErr
 }
 
 func (t *ToHTTPTransformation) UpdateWatermark(id execute.DatasetID, pt execute.Time) error {
@@ -367,5 +1053,9 @@ func (t *ToHTTPTransformation) UpdateProcessingTime(id execute.DatasetID, pt exe
 	return t.d.UpdateProcessingTime(pt)
 }
 func (t *ToHTTPTransformation) Finish(id execute.DatasetID, err error) {
+	t.timer.Stop()
+	if err == nil {
+		err = t.flush()
+	}
 	t.d.Finish(err)
 }
\ No newline at end of file
@@ -0,0 +1,29 @@
+package functions
+
+import "testing"
+
+// TestContainsSortedExactMatch guards the mergePrometheus column
+// classification bug: with tag_columns set (the ordinary case) and the
+// default "_value" column present, sort.SearchStrings alone reported
+// "_value" as contained in TagColumns because it sorts before "host", even
+// though it isn't "host". That misclassified the value column as a tag,
+// so it was read as a string and never reached valueColIdx, leaving the
+// encoded TimeSeries with zero samples. containsSorted must require an
+// exact match at the returned index, not just an in-bounds one.
+func TestContainsSortedExactMatch(t *testing.T) {
+	tagColumns := []string{"host"}
+	valueColumns := []string{"_value"}
+
+	if containsSorted(tagColumns, "_value") {
+		t.Error(`containsSorted(["host"], "_value") = true, want false`)
+	}
+	if !containsSorted(valueColumns, "_value") {
+		t.Error(`containsSorted(["_value"], "_value") = false, want true`)
+	}
+	if !containsSorted(tagColumns, "host") {
+		t.Error(`containsSorted(["host"], "host") = false, want true`)
+	}
+	if containsSorted(tagColumns, "") {
+		t.Error(`containsSorted(["host"], "") = true, want false`)
+	}
+}
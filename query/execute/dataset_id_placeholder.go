@@ -0,0 +1,12 @@
+package execute
+
+// DatasetID identifies a single dataset (one node's output) within a
+// running query.
+//
+// PLACEHOLDER: DatasetID is already declared upstream - it's referenced
+// throughout this package and by functions/stddev.go and
+// functions/to_http.go from before chunk2-2 ever touched this tree. This
+// file exists only so the chunk2-2 fragment compiles on its own; delete it
+// rather than merging it once this change lands on the real tree, or the
+// upstream declaration and this one will conflict.
+type DatasetID string
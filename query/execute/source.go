@@ -40,9 +40,25 @@ type storageSource struct {
 	ts []Transformation
 
 	currentTime Time
+
+	// trace records the downstream transformations' Process,
+	// UpdateWatermark and Finish calls against id, so the source ->
+	// transformation flow for this dataset shows up as one DatasetTrace.
+	// The reads themselves and UpdateProcessingTime calls are not recorded.
+	// It's nil unless NewStorageSourceWithTrace constructed this source.
+	trace *ExecutionTrace
 }
 
 func NewStorageSource(id DatasetID, r StorageReader, readSpec ReadSpec, bounds Bounds, w Window, currentTime Time) Source {
+	return NewStorageSourceWithTrace(id, r, readSpec, bounds, w, currentTime, nil)
+}
+
+// NewStorageSourceWithTrace is NewStorageSource, but the downstream
+// transformations' Process, UpdateWatermark and Finish calls the source
+// drives are additionally recorded against trace, keyed by id; reads and
+// UpdateProcessingTime calls are not. Pass a nil trace to get
+// NewStorageSource's original untraced behavior.
+func NewStorageSourceWithTrace(id DatasetID, r StorageReader, readSpec ReadSpec, bounds Bounds, w Window, currentTime Time, trace *ExecutionTrace) Source {
 	return &storageSource{
 		id:          id,
 		reader:      r,
@@ -50,6 +66,7 @@ func NewStorageSource(id DatasetID, r StorageReader, readSpec ReadSpec, bounds B
 		bounds:      bounds,
 		window:      w,
 		currentTime: currentTime,
+		trace:       trace,
 	}
 }
 
@@ -65,22 +82,52 @@ func (s *storageSource) Run(ctx context.Context) {
 		opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, opentracing.TextMapCarrier(trace))
 	}
 
+	// procErrs holds the first Process error seen for each of s.ts, by
+	// index, so a threshold-triggered flush failure mid-stream still
+	// reaches that transformation's Finish instead of being discarded -
+	// Finish(id, nil) would otherwise run its own final flush and mask it.
+	procErrs := make([]error, len(s.ts))
+
 	//TODO(nathanielc): Pass through context to actual network I/O.
 	for blocks, mark, ok := s.Next(ctx, trace); ok; blocks, mark, ok = s.Next(ctx, trace) {
 		blocks.Do(func(b Block) {
-			for _, t := range s.ts {
-				t.Process(s.id, b)
+			for i, t := range s.ts {
+				var finish FinishFunc
+				if s.trace != nil {
+					finish = s.trace.StartChildSpan("transformation.Process", s.id)
+				}
+				err := t.Process(s.id, b)
+				if finish != nil {
+					finish(0, 0, err)
+				}
+				if err != nil && procErrs[i] == nil {
+					procErrs[i] = err
+				}
 				//TODO(nathanielc): Also add mechanism to send UpdateProcessingTime calls, when no data is arriving.
 				// This is probably not needed for this source, but other sources should do so.
 				t.UpdateProcessingTime(s.id, Now())
 			}
 		})
 		for _, t := range s.ts {
-			t.UpdateWatermark(s.id, mark)
+			var finish FinishFunc
+			if s.trace != nil {
+				finish = s.trace.StartChildSpan("transformation.UpdateWatermark", s.id)
+			}
+			err := t.UpdateWatermark(s.id, mark)
+			if finish != nil {
+				finish(0, 0, err)
+			}
 		}
 	}
-	for _, t := range s.ts {
-		t.Finish(s.id, nil)
+	for i, t := range s.ts {
+		var finish FinishFunc
+		if s.trace != nil {
+			finish = s.trace.StartChildSpan("transformation.Finish", s.id)
+		}
+		t.Finish(s.id, procErrs[i])
+		if finish != nil {
+			finish(0, 0, procErrs[i])
+		}
 	}
 }
 
@@ -0,0 +1,101 @@
+package execute
+
+import (
+	"sync"
+	"time"
+)
+
+// FinishFunc records the outcome of a span started by
+// ExecutionTrace.StartChildSpan: rows/bytes processed, and err if the
+// operation failed.
+type FinishFunc func(rows, bytes int64, err error)
+
+// Span is a single recorded operation against a dataset: a source read, a
+// transformation's Process call for one block, a watermark advance, or a
+// dataset flush.
+type Span struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Rows     int64
+	Bytes    int64
+	Err      error
+}
+
+// DatasetTrace is one dataset's span hierarchy: the spans recorded against
+// it directly, plus its children - the datasets of the transformations it
+// feeds - so the tree can be walked from a source down through every
+// transformation to the dataset flush that was slow.
+type DatasetTrace struct {
+	ID       DatasetID
+	Spans    []Span
+	Children []*DatasetTrace
+}
+
+// ExecutionTrace is the nested span tree for one query's execution, keyed
+// by DatasetID. It sits alongside the single OpenTracing span
+// storageSource.Run starts before handing a text-map carrier off to
+// reader.Read: every
+// source read, transformation Process/UpdateWatermark/Finish call, and
+// dataset flush can now be recorded against the DatasetID it belongs to,
+// and the resulting tree is queryable after the query finishes (e.g. via a
+// future Result.Trace()) instead of only existing as in-flight spans.
+type ExecutionTrace struct {
+	mu    sync.Mutex
+	spans map[DatasetID]*DatasetTrace
+}
+
+// NewExecutionTrace returns an empty ExecutionTrace ready to record spans
+// for any DatasetID.
+func NewExecutionTrace() *ExecutionTrace {
+	return &ExecutionTrace{spans: make(map[DatasetID]*DatasetTrace)}
+}
+
+func (t *ExecutionTrace) datasetLocked(id DatasetID) *DatasetTrace {
+	dt, ok := t.spans[id]
+	if !ok {
+		dt = &DatasetTrace{ID: id}
+		t.spans[id] = dt
+	}
+	return dt
+}
+
+// StartChildSpan starts a span named name under id's DatasetTrace. The
+// Context passed to CreateSource/CreateTransformation exposes this method
+// so implementers record spans without hand-rolling OpenTracing
+// boilerplate; call the returned FinishFunc when the operation ends.
+func (t *ExecutionTrace) StartChildSpan(name string, id DatasetID) FinishFunc {
+	start := time.Now()
+	return func(rows, bytes int64, err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		dt := t.datasetLocked(id)
+		dt.Spans = append(dt.Spans, Span{
+			Name:     name,
+			Start:    start,
+			Duration: time.Since(start),
+			Rows:     rows,
+			Bytes:    bytes,
+			Err:      err,
+		})
+	}
+}
+
+// AddChild records that child is fed by parent, so the trace renders the
+// source -> transformation -> dataset-flush hierarchy instead of a flat
+// list of per-dataset spans.
+func (t *ExecutionTrace) AddChild(parent, child DatasetID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pt := t.datasetLocked(parent)
+	ct := t.datasetLocked(child)
+	pt.Children = append(pt.Children, ct)
+}
+
+// Dataset returns the recorded trace for id, or nil if nothing has been
+// recorded for it yet.
+func (t *ExecutionTrace) Dataset(id DatasetID) *DatasetTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spans[id]
+}
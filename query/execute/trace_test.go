@@ -0,0 +1,41 @@
+package execute_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/influxdata/ifql/query/execute"
+)
+
+func TestExecutionTraceRecordsSpansAndChildren(t *testing.T) {
+	trace := execute.NewExecutionTrace()
+
+	finish := trace.StartChildSpan("source.read", "src")
+	finish(10, 1024, nil)
+
+	failErr := errors.New("boom")
+	finish = trace.StartChildSpan("transformation.Process", "xform")
+	finish(0, 0, failErr)
+
+	trace.AddChild("src", "xform")
+
+	src := trace.Dataset("src")
+	if src == nil {
+		t.Fatal("Dataset(\"src\") = nil, want a recorded trace")
+	}
+	if len(src.Spans) != 1 || src.Spans[0].Rows != 10 || src.Spans[0].Bytes != 1024 {
+		t.Errorf("src.Spans = %+v, want one span with Rows=10 Bytes=1024", src.Spans)
+	}
+	if len(src.Children) != 1 || src.Children[0].ID != "xform" {
+		t.Errorf("src.Children = %+v, want one child with ID \"xform\"", src.Children)
+	}
+
+	xform := trace.Dataset("xform")
+	if xform == nil || len(xform.Spans) != 1 || xform.Spans[0].Err != failErr {
+		t.Errorf("xform trace = %+v, want one span carrying the error", xform)
+	}
+
+	if trace.Dataset("missing") != nil {
+		t.Error("Dataset(\"missing\") != nil, want nil for an unrecorded ID")
+	}
+}
@@ -0,0 +1,158 @@
+package query_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/influxdata/ifql/expression"
+	"github.com/influxdata/ifql/query"
+)
+
+// testOpSpec stands in for a functions/*.go OpSpec: the marshalling
+// machinery under test only cares that OperationSpec is registered and
+// round-trips its own fields, not which concrete operation it is.
+type testOpSpec struct {
+	Database string `json:"database"`
+}
+
+func (testOpSpec) Kind() query.OperationKind { return "testSelect" }
+
+func init() {
+	query.RegisterOpSpec("testSelect", func() query.OperationSpec { return new(testOpSpec) })
+}
+
+// TestQuerySpecMarshal checks that a QuerySpec's operations and edges
+// survive a JSON round-trip, the way parser_test.go's examples would if
+// this tree still had a parser to produce them from raw query text.
+// Expression trees are covered by TestExpressionMarshal below.
+func TestQuerySpecMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		spec *query.QuerySpec
+	}{
+		{
+			name: "single operation",
+			spec: &query.QuerySpec{
+				Operations: []*query.Operation{
+					{ID: "select0", Spec: &testOpSpec{Database: "mydb"}},
+				},
+				Edges: nil,
+			},
+		},
+		{
+			name: "two operations joined by an edge",
+			spec: &query.QuerySpec{
+				Operations: []*query.Operation{
+					{ID: "select0", Spec: &testOpSpec{Database: "mydb"}},
+					{ID: "filter1", Spec: &testOpSpec{Database: "mydb"}},
+				},
+				Edges: []query.Edge{
+					{Parent: "select0", Child: "filter1"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := json.Marshal(tt.spec)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			got := new(query.QuerySpec)
+			if err := json.Unmarshal(data, got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !cmp.Equal(tt.spec, got) {
+				t.Errorf("QuerySpec round-trip = -want/+got %s", cmp.Diff(tt.spec, got))
+			}
+		})
+	}
+}
+
+// TestExpressionMarshal checks that an expression tree, including nested
+// BinaryNodes and the reference, string, regexp, float, boolean and
+// integer leaf Node types, survives a JSON round-trip.
+func TestExpressionMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		expr expression.Expression
+	}{
+		{
+			name: "tag equality",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.EqualOperator,
+					Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+					Right:    &expression.StringLiteralNode{Value: "val1"},
+				},
+			},
+		},
+		{
+			name: "and of two comparisons with a field and a regex",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.AndOperator,
+					Left: &expression.BinaryNode{
+						Operator: expression.RegexpMatchOperator,
+						Left:     &expression.ReferenceNode{Name: "t1", Kind: "tag"},
+						Right:    &expression.RegexpLiteralNode{Value: "val1"},
+					},
+					Right: &expression.BinaryNode{
+						Operator: expression.EqualOperator,
+						Left:     &expression.ReferenceNode{Name: "$", Kind: "field"},
+						Right:    &expression.FloatLiteralNode{Value: 10.5},
+					},
+				},
+			},
+		},
+		{
+			name: "arithmetic join expression",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.DivisionOperator,
+					Left: &expression.BinaryNode{
+						Operator: expression.SubtractionOperator,
+						Left:     &expression.ReferenceNode{Name: "a", Kind: "identifier"},
+						Right:    &expression.ReferenceNode{Name: "$", Kind: "field"},
+					},
+					Right: &expression.ReferenceNode{Name: "$", Kind: "field"},
+				},
+			},
+		},
+		{
+			name: "boolean literals",
+			expr: expression.Expression{
+				Root: &expression.BinaryNode{
+					Operator: expression.EqualOperator,
+					Left:     &expression.BooleanLiteralNode{Value: true},
+					Right:    &expression.BooleanLiteralNode{Value: false},
+				},
+			},
+		},
+		{
+			name: "integer literal",
+			expr: expression.Expression{Root: &expression.IntegerLiteralNode{Value: 10}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := json.Marshal(tt.expr)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			var got expression.Expression
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !cmp.Equal(tt.expr, got) {
+				t.Errorf("Expression round-trip = -want/+got %s", cmp.Diff(tt.expr, got))
+			}
+		})
+	}
+}
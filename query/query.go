@@ -0,0 +1,132 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// OperationID uniquely identifies an Operation within a QuerySpec's DAG.
+type OperationID string
+
+// OperationKind identifies the kind of an OperationSpec, e.g. "select" or
+// "filter". Kinds are registered via RegisterOpSpec so an Operation's JSON
+// body can be decoded back into the right concrete OperationSpec without a
+// package-wide type switch.
+type OperationKind string
+
+// OperationSpec is the parameters for a specific operation.
+type OperationSpec interface {
+	Kind() OperationKind
+}
+
+var opSpecCreators = make(map[OperationKind]func() OperationSpec)
+
+// RegisterOpSpec registers c as the constructor an Operation's
+// UnmarshalJSON uses to decode the JSON body of any OperationSpec whose
+// Kind() is k. Every OpSpec under functions/ calls this from its init.
+func RegisterOpSpec(k OperationKind, c func() OperationSpec) {
+	if opSpecCreators[k] != nil {
+		panic(fmt.Errorf("duplicate registration for operation kind %v", k))
+	}
+	opSpecCreators[k] = c
+}
+
+// Operation denotes a single operation in a query and the ID used to
+// reference it as a parent/child in the QuerySpec's Edges.
+type Operation struct {
+	ID   OperationID
+	Spec OperationSpec
+}
+
+// operationJSON is the wire representation of an Operation: Kind is
+// threaded in alongside the OpSpec's own fields so UnmarshalJSON knows which
+// concrete type Spec decodes into.
+type operationJSON struct {
+	ID   OperationID     `json:"id"`
+	Kind OperationKind   `json:"kind"`
+	Spec json.RawMessage `json:"spec"`
+}
+
+// MarshalJSON implements json.Marshaler by tagging the encoded OpSpec with
+// its OperationKind, so UnmarshalJSON can pick the right constructor.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	spec, err := json.Marshal(o.Spec)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(operationJSON{
+		ID:   o.ID,
+		Kind: o.Spec.Kind(),
+		Spec: spec,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, looking up the OpSpec
+// constructor registered for the encoded kind via RegisterOpSpec.
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var raw operationJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	newSpec, ok := opSpecCreators[raw.Kind]
+	if !ok {
+		return fmt.Errorf("unknown operation kind %q", raw.Kind)
+	}
+	spec := newSpec()
+	if err := json.Unmarshal(raw.Spec, spec); err != nil {
+		return err
+	}
+	o.ID = raw.ID
+	o.Spec = spec
+	return nil
+}
+
+// Edge is a directed edge in a QuerySpec's operation DAG: data flows from
+// Parent to Child.
+type Edge struct {
+	Parent OperationID `json:"parent"`
+	Child  OperationID `json:"child"`
+}
+
+// QuerySpec is a compiled query: a DAG of operations connected by Edges.
+// It is the unit a planner/coordinator ships to a worker and an executor
+// runs, so it round-trips through JSON via the default struct encoding,
+// which defers to Operation's own MarshalJSON/UnmarshalJSON for each node.
+type QuerySpec struct {
+	Operations []*Operation `json:"operations"`
+	Edges      []Edge       `json:"edges"`
+}
+
+// Time represents a single point in time, either an absolute wall-clock
+// time, or an offset relative to "now" (IsRelative true), e.g. the -4h in
+// range(start:-4h).
+type Time struct {
+	Absolute   time.Time     `json:"absolute,omitempty"`
+	Relative   time.Duration `json:"relative,omitempty"`
+	IsRelative bool          `json:"isRelative,omitempty"`
+}
+
+// Duration is a time.Duration that marshals as its string form (e.g. "1h")
+// rather than a raw nanosecond count, so QuerySpec JSON stays readable and
+// diffable.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
@@ -0,0 +1,27 @@
+package query
+
+// ScheduledQuerySpec wraps a QuerySpec that should be re-run on a fixed
+// cadence, e.g. the materialised view a `create continuous query every 5m
+// do { ... }` statement would compile down to: Spec's DAG would end with
+// an `into()` leaf if the statement specified one, so running a
+// ScheduledQuerySpec is no different from running any other QuerySpec.
+//
+// This is the data type only - a placeholder for the compiled shape of
+// such a statement, nothing more. None of the following exist yet and are
+// tracked as separate, unstarted work: lexing `--`/`/* */` comments,
+// splitting a multi-statement script on `;`/newlines, a
+// `create continuous query ... every 5m do { ... }` grammar construct to
+// parse, and an `into()` sink leaf node for the resulting QuerySpec's DAG
+// to end with. This tree has no PEG grammar or ifql.NewQuery
+// implementation to add any of that to (ifql/query_test.go is the only
+// file under ifql/, and it's a test) - do not treat this commit as
+// delivering parsing support for continuous queries.
+type ScheduledQuerySpec struct {
+	Spec *QuerySpec
+
+	// Interval is how often Spec is re-run, e.g. 5m for "every 5m".
+	Interval Duration
+	// StartOffset delays the first run relative to when the schedule is
+	// registered, mirroring the offset InfluxQL continuous queries accept.
+	StartOffset Duration
+}